@@ -1,27 +1,13 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"sort"
-	"strings"
 
-	"github.com/tidwall/buntdb"
-	yaml "gopkg.in/yaml.v2"
 	"voidedtech.com/dotonex/internal/compose"
 	"voidedtech.com/dotonex/internal/core"
 )
 
-const (
-	bin        = "bin"
-	serverHash = "server"
-	perms      = 0600
-	vlanConfig = "vlans.cfg"
-)
-
 func main() {
 	if err := run(); err != nil {
 		core.WriteError("config failure", err)
@@ -29,398 +15,15 @@ func main() {
 	}
 }
 
-func piped(wrapper compose.Store, args []string) (string, error) {
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return "", err
-	}
-	o := strings.TrimSpace(stdout.String())
-	if len(o) > 0 {
-		wrapper.Debugging("stdout")
-		wrapper.Debugging(o)
-	}
-	e := stderr.String()
-	if len(e) > 0 {
-		wrapper.Debugging("stderr")
-		wrapper.Debugging(e)
-		return "", fmt.Errorf("command errored")
-	}
-	return o, nil
-}
-
-func validate(wrapper compose.Store) error {
-	wrapper.Debugging("validating inputs")
-	if err := mac(wrapper, false); err != nil {
-		return err
-	}
-	hash, err := core.MD4(wrapper.Token)
-	if err != nil {
-		return err
-	}
-	tokenKey := wrapper.NewKey(hash)
-	user, ok, err := wrapper.Get(tokenKey)
-	if err != nil {
-		return err
-	}
-	if ok {
-		wrapper.Debugging("token is known")
-	}
-	change := false
-	if user == "" {
-		command := []string{}
-		for _, c := range wrapper.Command {
-			text := c
-			if strings.Contains(c, "%s") {
-				text = fmt.Sprintf(c, wrapper.Token)
-			}
-			command = append(command, text)
-		}
-		output, err := piped(wrapper, command)
-		if err != nil {
-			return err
-		}
-		user, err = compose.TryGetUser(wrapper.Search, []byte(output), func(possibleUser string) bool {
-			return core.PathExists(filepath.Join(wrapper.Repo, possibleUser))
-		})
-		if err != nil {
-			return err
-		}
-		wrapper.Debugging(fmt.Sprintf("%s token changed", user))
-		if err := wrapper.Save(tokenKey, user); err != nil {
-			return err
-		}
-		change = true
-		wrapper.Debugging("token validated")
-	}
-	if user == "" {
-		return fmt.Errorf("empty user found")
-	}
-	wrapper.Debugging(fmt.Sprintf("user found: %s", user))
-	if change {
-		wrapper.Debugging("user is new")
-		userKey := wrapper.NewKey(user)
-		if err := wrapper.Save(userKey, wrapper.Token); err != nil {
-			return err
-		}
-		if err := build(wrapper, true); err != nil {
-			return err
-		}
-	}
-
-	// the mac has been checked as "clean" by being generically checked earlier
-	mac, _ := core.CleanMAC(wrapper.MAC)
-	userDir := filepath.Join(wrapper.Repo, user)
-	for _, file := range []string{mac, vlanConfig} {
-		if !core.PathExists(filepath.Join(userDir, file)) {
-			return fmt.Errorf("%s file not found", file)
-		}
-	}
-
-	wrapper.Debugging("validated")
-	return nil
-}
-
-func fetch(wrapper compose.Store) error {
-	for _, cmd := range []string{"fetch", "pull"} {
-		command := exec.Command("git", "-C", wrapper.Repo, cmd)
-		command.Stdout = os.Stdout
-		command.Stderr = os.Stderr
-		if err := command.Run(); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func server(wrapper compose.Store) error {
-	serverKey := wrapper.NewKey(serverHash)
-	val, ok, err := wrapper.Get(serverKey)
-	if err != nil {
-		return err
-	}
-	if ok {
-		if val == wrapper.Hash {
-			return nil
-		}
-	}
-	wrapper.Debugging("hash update")
-	if err := wrapper.Save(serverKey, wrapper.Hash); err != nil {
-		return err
-	}
-	return build(wrapper, true)
-}
-
-func mac(wrapper compose.Store, mab bool) error {
-	mac, ok := core.CleanMAC(wrapper.MAC)
-	if !ok {
-		return fmt.Errorf("invalid MAC")
-	}
-	dirs, err := os.ReadDir(wrapper.Repo)
-	if err != nil {
-		return err
-	}
-	for _, dir := range dirs {
-		if !dir.IsDir() {
-			continue
-		}
-		sub := filepath.Join(wrapper.Repo, dir.Name())
-		if core.PathExists(filepath.Join(sub, mac)) {
-			if mab {
-				if core.PathExists(filepath.Join(sub, vlanConfig)) {
-					wrapper.Debugging(fmt.Sprintf("%s MAC is user, not mab", mac))
-					continue
-				}
-			}
-			return nil
-		}
-	}
-	mode := "user"
-	if mab {
-		mode = "mab"
-	}
-	return fmt.Errorf("unable to find mac: %s (%s)", wrapper.MAC, mode)
-}
-
-func getHostapd(wrapper compose.Store, def compose.Definition) ([]compose.Hostapd, error) {
-	hashKey := wrapper.NewKey(serverHash)
-	hash, ok, err := wrapper.Get(hashKey)
-	if err != nil {
-		return nil, err
-	}
-	if !ok {
-		return nil, fmt.Errorf("no server hash found")
-	}
-	dirs, err := os.ReadDir(wrapper.Repo)
-	if err != nil {
-		return nil, err
-	}
-	if len(hash) == 0 {
-		return nil, fmt.Errorf("empty hash")
-	}
-	var result []compose.Hostapd
-	for _, dir := range dirs {
-		if !dir.IsDir() {
-			continue
-		}
-		name := dir.Name()
-		path := filepath.Join(wrapper.Repo, name)
-		if id, ok := def.IsVLAN(name); ok {
-			wrapper.Debugging(fmt.Sprintf("%s (MAB)", name))
-			sub, err := os.ReadDir(path)
-			if err != nil {
-				return nil, err
-			}
-			for _, mac := range sub {
-				cleaned, ok := core.CleanMAC(mac.Name())
-				if !ok {
-					continue
-				}
-				wrapper.Debugging(fmt.Sprintf(" -> %s", cleaned))
-				result = append(result, compose.NewHostapd(cleaned, cleaned, id))
-			}
-			continue
-		}
-		possible := filepath.Join(path, vlanConfig)
-		if !core.PathExists(possible) {
-			continue
-		}
-		secretKey := wrapper.NewKey(name)
-		loginName, ok, err := wrapper.Get(secretKey)
-		if err != nil {
-			return nil, err
-		}
-		if !ok {
-			continue
-		}
-		wrapper.Debugging(fmt.Sprintf("%s (USER)", name))
-		if len(loginName) == 0 {
-			core.WriteWarn("empty login name")
-			continue
-		}
-		loginName = core.NewUserLogin(name, loginName)
-		b, err := os.ReadFile(possible)
-		if err != nil {
-			return nil, err
-		}
-		d := compose.Definition{}
-		if err := yaml.Unmarshal(b, &d); err != nil {
-			core.WriteError("unable to read user yaml", err)
-			continue
-		}
-		if err := d.ValidateMembership(); err != nil {
-			core.WriteError("invalid memberships found", err)
-			continue
-		}
-		first := true
-		for _, member := range d.Membership {
-			id, ok := def.IsVLAN(member.VLAN)
-			if !ok {
-				core.WriteWarn(fmt.Sprintf("invalid VLAN %s", member.VLAN))
-				continue
-			}
-			if first {
-				result = append(result, compose.NewHostapd(loginName, hash, id))
-				first = false
-			}
-			result = append(result, compose.NewHostapd(core.NewUserVLANLogin(loginName, member.VLAN), hash, id))
-		}
-	}
-	return result, nil
-}
-
-func getVLANs(wrapper compose.Store) (compose.Definition, error) {
-	cfg := filepath.Join(wrapper.Repo, vlanConfig)
-	d := compose.Definition{}
-	if !core.PathExists(cfg) {
-		return d, fmt.Errorf("no root vlan config found")
-	}
-	b, err := os.ReadFile(cfg)
-	if err != nil {
-		return d, err
-	}
-	if err := yaml.Unmarshal(b, &d); err != nil {
-		return d, err
-	}
-
-	if err := d.ValidateVLANs(); err != nil {
-		return d, err
-	}
-	return d, nil
-}
-
-func configure(wrapper compose.Store) error {
-	wrapper.Debugging("configuring")
-	vlans, err := getVLANs(wrapper)
-	if err != nil {
-		return err
-	}
-	hostapd, err := getHostapd(wrapper, vlans)
-	if err != nil {
-		return err
-	}
-	var eapUsers []string
-	for _, h := range hostapd {
-		eapUsers = append(eapUsers, h.String())
-	}
-	if len(eapUsers) == 0 {
-		return fmt.Errorf("no hostapd configurations found")
-	}
-	sort.Strings(eapUsers)
-	hostapdFile := filepath.Join(wrapper.Repo, bin, "eap_users")
-	hostapdText := strings.Join(eapUsers, "\n\n") + "\n"
-	if core.PathExists(hostapdFile) {
-		b, err := os.ReadFile(hostapdFile)
-		if err != nil {
-			return err
-		}
-		if hostapdText == string(b) {
-			wrapper.Debugging("no hostapd changes")
-			return nil
-		}
-	}
-	if err := os.WriteFile(hostapdFile, []byte(hostapdText), perms); err != nil {
-		return err
-	}
-	return resetHostapd(wrapper)
-}
-
-func resetHostapd(wrapper compose.Store) error {
-	wrapper.Debugging("hostapd reset")
-	pids, err := piped(wrapper, []string{"pidof", "hostapd"})
-	if err != nil {
-		core.WriteWarn(fmt.Sprintf("unable to get hostapd pids: %v", err))
-		return nil
-	}
-	for _, pid := range strings.Split(pids, " ") {
-		p := strings.TrimSpace(pid)
-		if len(p) == 0 {
-			continue
-		}
-		cmd := exec.Command("kill", "-HUP", p)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func build(wrapper compose.Store, force bool) error {
-	if !force {
-		last, err := piped(wrapper, []string{"git", "-C", wrapper.Repo, "log", "-n", "1", "--format=%h"})
-		if err != nil {
-			return err
-		}
-		last = strings.TrimSpace(last)
-		if len(last) == 0 {
-			return fmt.Errorf("no commit retrieved")
-		}
-		lastKey := wrapper.NewKey("last")
-		val, ok, err := wrapper.Get(lastKey)
-		if err != nil {
-			return err
-		}
-		if ok {
-			if val == last {
-				wrapper.Debugging("no config changes found")
-				return nil
-			}
-		}
-		if err := wrapper.Save(lastKey, last); err != nil {
-			return err
-		}
-	}
-	return configure(wrapper)
-}
-
 func run() error {
 	flags := core.GetComposeFlags()
 	if !flags.Valid() {
 		return fmt.Errorf("invalid arguments")
 	}
-	if !core.PathExists(flags.Repo) {
-		return fmt.Errorf("repository invalid/does not exist")
-	}
-	target := filepath.Join(flags.Repo, bin)
-	if !core.PathExists(target) {
-		flags.Debugging("creating target")
-		if err := os.Mkdir(target, 0700); err != nil {
-			return err
-		}
-	}
-	db, err := buntdb.Open(filepath.Join(target, "dotonex.db"))
+	backend, err := compose.Open(flags, 0)
 	if err != nil {
 		return err
 	}
-	wrapper := compose.NewStore(flags, db)
-	switch wrapper.Mode {
-	case core.ModeValidate:
-		if len(wrapper.Command) == 0 || len(wrapper.Token) == 0 || len(wrapper.MAC) == 0 {
-			return fmt.Errorf("missing flags for validation")
-		}
-		return validate(wrapper)
-	case core.ModeServer:
-		if len(wrapper.Hash) == 0 {
-			return fmt.Errorf("missing flags for server")
-		}
-		return server(wrapper)
-	case core.ModeMAC:
-		if len(wrapper.MAC) == 0 {
-			return fmt.Errorf("missing flags for mac")
-		}
-		return mac(wrapper, true)
-	case core.ModeFetch:
-		return fetch(wrapper)
-	case core.ModeBuild:
-		return build(wrapper, false)
-	case core.ModeRebuild:
-		return build(wrapper, true)
-	default:
-		return fmt.Errorf("unknown mode")
-	}
+	defer backend.Close()
+	return backend.Run()
 }
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	yaml "gopkg.in/yaml.v2"
@@ -20,19 +26,28 @@ var (
 	serverAddress *net.UDPAddr
 	clients       = make(map[string]*connection)
 	clientLock    = new(sync.Mutex)
+	configLock    = new(sync.Mutex)
+	reloadLock    = new(sync.Mutex)
 	erroredCount  = 0
 )
 
+const (
+	// radsecPrefix marks radsec connections in the clients (udp clients are by address)
+	radsecPrefix = "radsec:"
+)
+
 type (
 	connection struct {
-		client *net.UDPAddr
 		server *net.UDPConn
+		relay  *runner.Relay
+		reply  func([]byte) error
 	}
 )
 
-func newConnection(srv, cli *net.UDPAddr) *connection {
+func newConnection(ctx *runner.Context, srv *net.UDPAddr, reply func([]byte) error) *connection {
 	conn := new(connection)
-	conn.client = cli
+	conn.reply = reply
+	conn.relay = ctx.NewRelay()
 	serverUDP, err := net.DialUDP("udp", nil, srv)
 	if err != nil {
 		core.WriteError("dial udp", err)
@@ -65,17 +80,25 @@ func runConnection(ctx *runner.Context, conn *connection) {
 	for {
 		n, err := conn.server.Read(buffer[0:])
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			core.WriteError("unable to read buffer", err)
 			continue
 		}
-		if _, err := proxy.WriteToUDP(buffer[0:n], conn.client); err != nil {
+		b, err := conn.relay.Response(buffer[0:n])
+		if err != nil {
+			core.WriteError("unable to resign response", err)
+			continue
+		}
+		if err := conn.reply(b); err != nil {
 			core.WriteError("error relaying", err)
 		}
 	}
 }
 
 func runProxy(ctx *runner.Context) {
-	if ctx.Debug {
+	if ctx.Debugging() {
 		core.WriteInfo("=============WARNING==================")
 		core.WriteInfo("debugging is enabled!")
 		core.WriteInfo("dumps from debugging may contain secrets")
@@ -90,26 +113,13 @@ func runProxy(ctx *runner.Context) {
 			core.WriteError("read from udp", err)
 			continue
 		}
-		addr := cliaddr.String()
-		clientLock.Lock()
-		conn, found := clients[addr]
-		if !found {
-			conn = newConnection(serverAddress, cliaddr)
-			if conn == nil {
-				erroredCount++
-				clientLock.Unlock()
-				continue
-			}
-			erroredCount = 0
-			clients[addr] = conn
-			clientLock.Unlock()
-			go runConnection(ctx, conn)
-		} else {
-			clientLock.Unlock()
-		}
 		buffered := []byte(buffer[0:n])
-		auth := runner.HandlePreAuth(ctx, buffered, cliaddr, func(buffer []byte) {
-			if _, err := proxy.WriteToUDP(buffer, conn.client); err != nil {
+		reply := func(b []byte) error {
+			_, err := proxy.WriteToUDP(b, cliaddr)
+			return err
+		}
+		packet, auth := runner.HandlePreAuth(ctx, buffered, cliaddr, func(buffer []byte) {
+			if err := reply(buffer); err != nil {
 				core.WriteError("unable to proxy", err)
 			}
 		})
@@ -117,9 +127,124 @@ func runProxy(ctx *runner.Context) {
 			core.WriteDebug("client failed preauth check")
 			continue
 		}
-		if _, err := conn.server.Write(buffer[0:n]); err != nil {
-			core.WriteError("unable to write to the server", err)
+		// connections (and hostapd sockets) are only created for clients with a known
+		// secret, unparsed packets are only passed on for existing connections
+		conn := clientConnection(ctx, cliaddr.String(), reply, packet.Error == nil)
+		if conn == nil {
+			continue
+		}
+		forward(conn, packet, auth)
+	}
+}
+
+func clientConnection(ctx *runner.Context, addr string, reply func([]byte) error, create bool) *connection {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+	if conn, found := clients[addr]; found || !create {
+		return conn
+	}
+	conn := newConnection(ctx, serverAddress, reply)
+	if conn == nil {
+		erroredCount++
+		return nil
+	}
+	erroredCount = 0
+	clients[addr] = conn
+	go runConnection(ctx, conn)
+	return conn
+}
+
+// removeConnection stops tracking a client connection, closing its server socket
+func removeConnection(addr string) {
+	clientLock.Lock()
+	conn, found := clients[addr]
+	delete(clients, addr)
+	clientLock.Unlock()
+	if found {
+		conn.server.Close()
+	}
+}
+
+func forward(conn *connection, packet *runner.ClientPacket, auth bool) {
+	if !auth {
+		core.WriteDebug("client failed preauth check")
+		return
+	}
+	b, err := conn.relay.Request(packet)
+	if err != nil {
+		core.WriteError("unable to resign request", err)
+		return
+	}
+	if _, err := conn.server.Write(b); err != nil {
+		core.WriteError("unable to write to the server", err)
+	}
+}
+
+func runRadSec(ctx *runner.Context, listener net.Listener, timeout, idle time.Duration) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			core.WriteError("radsec accept", err)
+			continue
+		}
+		go handleRadSec(ctx, client, timeout, idle)
+	}
+}
+
+func handleRadSec(ctx *runner.Context, client net.Conn, timeout, idle time.Duration) {
+	defer client.Close()
+	if secured, ok := client.(*tls.Conn); ok {
+		if err := secured.SetDeadline(time.Now().Add(timeout)); err != nil {
+			core.WriteError("radsec deadline", err)
+			return
+		}
+		if err := secured.Handshake(); err != nil {
+			core.WriteError("radsec handshake", err)
+			return
+		}
+	}
+	writeLock := new(sync.Mutex)
+	// radsec connections count towards the connection (and failure) limits as udp clients do
+	addr := radsecPrefix + client.RemoteAddr().String()
+	conn := clientConnection(ctx, addr, func(b []byte) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		if err := client.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+		_, err := client.Write(b)
+		return err
+	}, true)
+	if conn == nil {
+		return
+	}
+	defer removeConnection(addr)
+	for {
+		if err := client.SetReadDeadline(time.Now().Add(idle)); err != nil {
+			core.WriteError("radsec deadline", err)
+			return
+		}
+		b, err := runner.ReadFrame(client)
+		if err != nil {
+			var timedOut net.Error
+			switch {
+			case err == io.EOF:
+			case errors.As(err, &timedOut) && timedOut.Timeout():
+				core.WriteDebug("radsec connection idle")
+			default:
+				core.WriteError("radsec read", err)
+			}
+			return
 		}
+		packet, auth := runner.HandleRadSec(ctx, b, client.RemoteAddr(), func(buffer []byte) {
+			if err := conn.reply(buffer); err != nil {
+				core.WriteError("unable to reply over radsec", err)
+			}
+		})
+		forward(conn, packet, auth)
 	}
 }
 
@@ -131,7 +256,11 @@ func account(ctx *runner.Context) {
 			core.WriteError("accounting udp error", err)
 			continue
 		}
-		ctx.Account(runner.NewClientPacket(buffer[0:n], cliaddr))
+		runner.HandleAccounting(ctx, buffer[0:n], cliaddr, func(b []byte) {
+			if _, err := proxy.WriteToUDP(b, cliaddr); err != nil {
+				core.WriteError("unable to write accounting response", err)
+			}
+		})
 	}
 }
 
@@ -159,16 +288,14 @@ func monitorCount(debug bool, title string, c chan bool, state core.MonitorState
 	}
 }
 
-func main() {
-	p := core.Flags()
-	core.ConfigureLogging(p.Debug, p.Instance)
+func loadConfig(p core.ProcessFlags) (*core.Configuration, error) {
 	b, err := os.ReadFile(filepath.Join(p.Directory, p.Instance+core.InstanceConfig))
 	if err != nil {
-		core.Fatal("unable to load config", err)
+		return nil, fmt.Errorf("unable to load config: %v", err)
 	}
 	conf := &core.Configuration{}
 	if err := yaml.Unmarshal(b, conf); err != nil {
-		core.Fatal("unable to parse config", err)
+		return nil, fmt.Errorf("unable to parse config: %v", err)
 	}
 	if conf.Preload != nil && len(conf.Preload) > 0 {
 		combined := &core.Configuration{}
@@ -176,18 +303,116 @@ func main() {
 			core.WriteInfo(fmt.Sprintf("preloading: %s", preload))
 			loaded, err := os.ReadFile(preload)
 			if err != nil {
-				core.Fatal(fmt.Sprintf("unable to preload: %s", preload), err)
+				return nil, fmt.Errorf("unable to preload: %s (%v)", preload, err)
 			}
 			if err := yaml.Unmarshal(loaded, combined); err != nil {
-				core.Fatal(fmt.Sprintf("unable to parse yaml: %s", preload), err)
+				return nil, fmt.Errorf("unable to parse yaml: %s (%v)", preload, err)
 			}
 		}
 		conf = combined
 		if err := yaml.Unmarshal(b, conf); err != nil {
-			core.Fatal("unable to overlay root config", err)
+			return nil, fmt.Errorf("unable to overlay root config: %v", err)
 		}
 	}
 	conf.Defaults(b)
+	return conf, nil
+}
+
+func reload(ctx *runner.Context, conf *core.Configuration, p core.ProcessFlags) ([]string, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	next, err := loadConfig(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := core.ConfigureLogFormat(next.LogFormat); err != nil {
+		return nil, err
+	}
+	if !conf.Accounting {
+		if err := runner.Reconfigure(next); err != nil {
+			if err := core.ConfigureLogFormat(conf.LogFormat); err != nil {
+				core.WriteError("unable to restore log format", err)
+			}
+			return nil, err
+		}
+	}
+	configLock.Lock()
+	restart := conf.Reload(next)
+	configLock.Unlock()
+	ctx.Reload(conf)
+	for _, setting := range restart {
+		core.WriteWarn(fmt.Sprintf("restart required to change: %s", setting))
+	}
+	core.WriteInfo("configuration reloaded")
+	return restart, nil
+}
+
+func runControl(ctx *runner.Context, conf *core.Configuration, p core.ProcessFlags) {
+	control := runner.NewControl()
+	control.Register("config", "dump the effective configuration (secrets redacted)", func(args []string) (string, error) {
+		configLock.Lock()
+		redacted := conf.Redacted()
+		configLock.Unlock()
+		b, err := yaml.Marshal(redacted)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	})
+	control.Register("clients", "list proxied client connections", func(args []string) (string, error) {
+		clientLock.Lock()
+		defer clientLock.Unlock()
+		var addrs []string
+		for addr := range clients {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		return strings.Join(addrs, "\n"), nil
+	})
+	control.Register("flush", "write buffered plugin logs now", func(args []string) (string, error) {
+		configLock.Lock()
+		dir := conf.Log
+		configLock.Unlock()
+		runner.WritePluginMessages(dir, p.Instance)
+		return "logs flushed", nil
+	})
+	control.Register("reload", "reload the configuration (same as SIGHUP)", func(args []string) (string, error) {
+		restart, err := reload(ctx, conf, p)
+		if err != nil {
+			return "", err
+		}
+		result := "configuration reloaded"
+		for _, setting := range restart {
+			result = fmt.Sprintf("%s\nrestart required to change: %s", result, setting)
+		}
+		return result, nil
+	})
+	control.Register("debug", "get or set debug logging (on|off)", func(args []string) (string, error) {
+		result, err := runner.DebugCommand(args)
+		if err == nil {
+			ctx.SetDebug(core.Debugging())
+		}
+		return result, err
+	})
+	socket := core.ControlSocket(conf.Control.Directory, p.Instance)
+	listener, err := control.Listen(socket)
+	if err != nil {
+		core.Fatal("unable to open control socket", err)
+	}
+	core.WriteInfo(fmt.Sprintf("control socket: %s", socket))
+	go control.Serve(listener)
+}
+
+func main() {
+	p := core.Flags()
+	core.ConfigureLogging(p.Debug, p.Instance)
+	conf, err := loadConfig(p)
+	if err != nil {
+		core.Fatal("configuration", err)
+	}
+	if err := core.ConfigureLogFormat(conf.LogFormat); err != nil {
+		core.Fatal("invalid log format", err)
+	}
 	if p.Debug {
 		conf.Dump()
 	}
@@ -202,28 +427,60 @@ func main() {
 		core.Fatal("proxy setup", err)
 	}
 
-	ctx := &runner.Context{Debug: p.Debug}
+	ctx := &runner.Context{}
+	ctx.SetDebug(p.Debug)
 	ctx.FromConfig(conf)
+	if conf.Metrics.Enable {
+		runner.RegisterGauge("dotonex_proxy_connections", "active proxy client connections", func() float64 {
+			clientLock.Lock()
+			defer clientLock.Unlock()
+			return float64(len(clients))
+		})
+		runner.RegisterGauge("dotonex_client_errors", "client connection failures since the last success", func() float64 {
+			clientLock.Lock()
+			defer clientLock.Unlock()
+			return float64(erroredCount)
+		})
+		go runner.ServeMetrics(conf.Metrics.Bind)
+	}
+	if conf.Control.Enable {
+		runControl(ctx, conf, p)
+	}
 
 	if !conf.Internals.NoLogs {
-		logBuffer := time.Duration(conf.Internals.Logs) * time.Second
 		go func() {
 			for {
+				configLock.Lock()
+				logBuffer := time.Duration(conf.Internals.Logs) * time.Second
+				configLock.Unlock()
 				time.Sleep(logBuffer)
-				if ctx.Debug {
+				if ctx.Debugging() {
 					core.WriteDebug("flushing logs")
 				}
-				runner.WritePluginMessages(conf.Log, p.Instance)
+				configLock.Lock()
+				dir := conf.Log
+				configLock.Unlock()
+				runner.WritePluginMessages(dir, p.Instance)
 			}
 		}()
 	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			core.WriteInfo("reloading configuration")
+			if _, err := reload(ctx, conf, p); err != nil {
+				core.WriteError("unable to reload configuration", err)
+			}
+		}
+	}()
 	interrupt := make(chan bool)
 	if !conf.Internals.NoInterrupt {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt)
 		go func() {
 			for range c {
-				if ctx.Debug {
+				if ctx.Debugging() {
 					core.WriteDebug("interrupt signal received")
 				}
 				interrupt <- true
@@ -238,12 +495,12 @@ func main() {
 		go func() {
 			for {
 				time.Sleep(check)
-				if ctx.Debug {
+				if ctx.Debugging() {
 					core.WriteDebug("lifespan wakeup")
 				}
 				now := time.Now()
 				if !core.IntegerIn(now.Hour(), conf.Internals.LifeHours) {
-					if ctx.Debug {
+					if ctx.Debugging() {
 						core.WriteDebug("lifespan in quiet hours")
 					}
 					continue
@@ -258,9 +515,40 @@ func main() {
 	clientFailures := make(chan bool)
 	if conf.Accounting {
 		core.WriteInfo("accounting mode")
+		if conf.Sessions.Enable {
+			if err := runner.ConfigureSessions(conf.Sessions.File, time.Duration(conf.Sessions.Timeout)*time.Minute); err != nil {
+				core.Fatal("unable to load sessions", err)
+			}
+			go func() {
+				for {
+					time.Sleep(1 * time.Minute)
+					runner.ExpireSessions()
+					runner.WriteSessions()
+				}
+			}()
+		}
+		if conf.DynAuth.Enable {
+			if !conf.Sessions.Enable {
+				core.Fatal("unable to setup dynauth", fmt.Errorf("dynauth requires sessions"))
+			}
+			if err := runner.ConfigureDynAuth(ctx, conf); err != nil {
+				core.Fatal("unable to setup dynauth", err)
+			}
+			core.WriteInfo("dynauth enabled")
+			check := time.Duration(conf.DynAuth.Check) * time.Second
+			go func() {
+				for {
+					time.Sleep(check)
+					runner.CheckAssignments()
+				}
+			}()
+		}
 		go account(ctx)
 	} else {
 		core.WriteInfo("proxy mode")
+		if conf.DynAuth.Enable {
+			core.WriteWarn("dynauth requires accounting mode (sessions)")
+		}
 		if conf.Compose.Static {
 			runner.SetAllowed(conf.Compose.Payload)
 		} else {
@@ -268,13 +556,25 @@ func main() {
 				core.Fatal("unable to setup management of configs", err)
 			}
 		}
-		monitorCount(ctx.Debug, "max connection", maxConns, conf.Internals.MaxConnections, func() int {
+		monitorCount(ctx.Debugging(), "max connection", maxConns, conf.Internals.MaxConnections, func() int {
 			return len(clients)
 		})
-		monitorCount(ctx.Debug, "client errors", clientFailures, conf.Internals.ClientFailures, func() int {
+		monitorCount(ctx.Debugging(), "client errors", clientFailures, conf.Internals.ClientFailures, func() int {
 			return erroredCount
 		})
 		go runProxy(ctx)
+		if conf.RadSec.Enable {
+			cfg, err := runner.NewRadSecConfig(conf.RadSec.Cert, conf.RadSec.Key, conf.RadSec.CA)
+			if err != nil {
+				core.Fatal("unable to setup radsec", err)
+			}
+			listener, err := tls.Listen("tcp", fmt.Sprintf(":%d", conf.RadSec.Bind), cfg)
+			if err != nil {
+				core.Fatal("unable to listen for radsec", err)
+			}
+			core.WriteInfo("radsec listening")
+			go runRadSec(ctx, listener, time.Duration(conf.RadSec.Timeout)*time.Second, time.Duration(conf.RadSec.Idle)*time.Second)
+		}
 	}
 	select {
 	case <-clientFailures:
@@ -286,6 +586,7 @@ func main() {
 	case <-lifecycle:
 		core.WriteInfo("lifecyle...")
 	}
+	runner.WriteSessions()
 	runner.WritePluginMessages(conf.Log, p.Instance)
 	if conf.Quit.Wait {
 		core.WriteInfo("shutting down")
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
@@ -10,7 +11,28 @@ import (
 	"voidedtech.com/dotonex/internal/core"
 )
 
+func ctl(args []string) {
+	set := flag.NewFlagSet("ctl", flag.ExitOnError)
+	dir := set.String("control", "/run/dotonex/", "Control socket directory")
+	instance := set.String("instance", "", "Instance name")
+	if err := set.Parse(args); err != nil {
+		core.Fatal("invalid ctl arguments", err)
+	}
+	if len(*instance) == 0 {
+		core.Fatal("no instance given", fmt.Errorf("--instance is required"))
+	}
+	result, err := core.ControlRequest(core.ControlSocket(*dir, *instance), set.Args())
+	if err != nil {
+		core.Fatal("control command failed", err)
+	}
+	fmt.Print(result)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		ctl(os.Args[2:])
+		return
+	}
 	flags := core.Flags()
 	instances := []string{}
 	options, err := os.ReadDir(flags.Directory)
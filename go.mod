@@ -3,8 +3,12 @@ module voidedtech.com/dotonex
 go 1.16
 
 require (
-	github.com/tidwall/buntdb v1.2.3 // indirect
-	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
-	layeh.com/radius v0.0.0-20201203135236-838e26d0c9be // indirect
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.4.1
+	github.com/tidwall/buntdb v1.2.3
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
+	golang.org/x/sys v0.7.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0
+	layeh.com/radius v0.0.0-20201203135236-838e26d0c9be
 )
@@ -0,0 +1,90 @@
+package compose
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	assignmentsFile = "assignments"
+)
+
+type (
+	// Assignment is an eap_users login, the VLAN it is assigned, and the MACs it may use
+	Assignment struct {
+		Login string   `json:"login"`
+		VLAN  string   `json:"vlan"`
+		MACs  []string `json:"macs"`
+	}
+
+	// Assignments are the assignments of a build by login
+	Assignments map[string]Assignment
+)
+
+// AssignmentsFile gets the path of the assignments written with eap_users
+func AssignmentsFile(repo string) string {
+	return filepath.Join(repo, bin, assignmentsFile)
+}
+
+// ReadAssignments reads the assignments of the last build
+func ReadAssignments(file string) (Assignments, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var list []Assignment
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+	result := make(Assignments)
+	for _, a := range list {
+		result[a.Login] = a
+	}
+	return result, nil
+}
+
+// Find gets the assignment of a login (MAB logins are upper case in eap_users)
+func (a Assignments) Find(login string) (Assignment, bool) {
+	if found, ok := a[login]; ok {
+		return found, true
+	}
+	found, ok := a[strings.ToUpper(login)]
+	return found, ok
+}
+
+// Allows checks if a MAC may use an assignment
+func (a Assignment) Allows(mac string) bool {
+	for _, m := range a.MACs {
+		if m == mac {
+			return true
+		}
+	}
+	return false
+}
+
+func writeAssignments(wrapper Wrapper, hostapd []Hostapd) error {
+	list := []Assignment{}
+	for _, h := range hostapd {
+		list = append(list, h.assignment())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Login < list[j].Login
+	})
+	b, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return err
+	}
+	file := AssignmentsFile(wrapper.Repo)
+	if existing, err := os.ReadFile(file); err == nil && string(existing) == string(b) {
+		return nil
+	}
+	// the runner reads assignments while builds happen
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b, perms); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,59 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func TestAssignments(t *testing.T) {
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	b, err := Open(core.ComposeFlags{Repo: repo, Command: []string{"echo", `{"username": "user.name"}`}}, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	defer b.Close()
+	if err := b.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	if err := b.Validate("token", "112233445566"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	a, err := ReadAssignments(AssignmentsFile(repo))
+	if err != nil {
+		t.Errorf("unable to read assignments: %v", err)
+		return
+	}
+	if len(a) != 3 {
+		t.Errorf("invalid assignments: %v", a)
+	}
+	mab, ok := a.Find("aabbccddeeff")
+	if !ok || mab.VLAN != "2" || !mab.Allows("aabbccddeeff") {
+		t.Error("invalid mab assignment")
+	}
+	user, ok := a.Find("user.name:token@vlan.abc")
+	if !ok || user.VLAN != "1" || !user.Allows("112233445566") || user.Allows("aabbccddeeff") {
+		t.Error("invalid user assignment")
+	}
+	if err := os.Remove(filepath.Join(repo, "user.name", "112233445566")); err != nil {
+		t.Error("unable to remove mac")
+	}
+	if err := b.Rebuild(); err != nil {
+		t.Errorf("rebuild failed: %v", err)
+	}
+	a, err = ReadAssignments(AssignmentsFile(repo))
+	if err != nil {
+		t.Errorf("unable to read assignments: %v", err)
+	}
+	user, ok = a.Find("user.name:token")
+	if !ok || user.Allows("112233445566") {
+		t.Error("mac should be removed")
+	}
+	if _, ok := a.Find("other"); ok {
+		t.Error("unknown login")
+	}
+}
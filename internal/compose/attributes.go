@@ -0,0 +1,133 @@
+package compose
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	attrString  = "s"
+	attrInteger = "d"
+	attrHex     = "x"
+	vendorAttr  = 26
+)
+
+type (
+	// Attribute is a RADIUS reply attribute, by name or by (vendor) id and type
+	Attribute struct {
+		Name   string
+		ID     int
+		Vendor int
+		Type   string
+		Value  string
+	}
+)
+
+var (
+	// knownAttributes are attributes that may be given by name
+	knownAttributes = map[string]Attribute{
+		"filter-id":             {ID: 11, Type: attrString},
+		"reply-message":         {ID: 18, Type: attrString},
+		"class":                 {ID: 25, Type: attrString},
+		"session-timeout":       {ID: 27, Type: attrInteger},
+		"idle-timeout":          {ID: 28, Type: attrInteger},
+		"termination-action":    {ID: 29, Type: attrInteger},
+		"acct-interim-interval": {ID: 85, Type: attrInteger},
+		"cisco-avpair":          {Vendor: 9, ID: 1, Type: attrString},
+		"aruba-user-role":       {Vendor: 14823, ID: 1, Type: attrString},
+	}
+	// tunnelAttributes are always sent (for the VLAN) and may not be set
+	tunnelAttributes = []int{64, 65, 81}
+)
+
+func (a Attribute) resolve() (Attribute, error) {
+	if a.Name == "" {
+		return a, nil
+	}
+	known, ok := knownAttributes[strings.ToLower(a.Name)]
+	if !ok {
+		return a, fmt.Errorf("unknown attribute: %s", a.Name)
+	}
+	known.Name = a.Name
+	known.Value = a.Value
+	return known, nil
+}
+
+func (a Attribute) bytes() ([]byte, error) {
+	switch a.Type {
+	case attrString:
+		if strings.ContainsAny(a.Value, "\r\n") {
+			return nil, fmt.Errorf("invalid attribute value")
+		}
+		return []byte(a.Value), nil
+	case attrInteger:
+		i, err := strconv.ParseUint(a.Value, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(i))
+		return b, nil
+	case attrHex:
+		return hex.DecodeString(a.Value)
+	}
+	return nil, fmt.Errorf("unknown attribute type: %s", a.Type)
+}
+
+// Render converts an attribute to hostapd's radius_accept_attr (vendor attributes are hex)
+func (a Attribute) Render() (string, error) {
+	resolved, err := a.resolve()
+	if err != nil {
+		return "", err
+	}
+	if resolved.ID < 1 || resolved.ID > 255 {
+		return "", fmt.Errorf("invalid attribute id: %d", resolved.ID)
+	}
+	value, err := resolved.bytes()
+	if err != nil {
+		return "", err
+	}
+	if resolved.Vendor == 0 {
+		if resolved.ID == vendorAttr {
+			return "", fmt.Errorf("vendor attributes require a vendor")
+		}
+		for _, id := range tunnelAttributes {
+			if resolved.ID == id {
+				return "", fmt.Errorf("vlan attributes can not be set: %d", id)
+			}
+		}
+		if len(value) == 0 || len(value) > 253 {
+			return "", fmt.Errorf("invalid attribute length: %d", resolved.ID)
+		}
+		text := resolved.Value
+		if resolved.Type == attrHex {
+			text = hex.EncodeToString(value)
+		}
+		return fmt.Sprintf("radius_accept_attr=%d:%s:%s", resolved.ID, resolved.Type, text), nil
+	}
+	if resolved.Vendor < 0 || len(value) == 0 || len(value) > 247 {
+		return "", fmt.Errorf("invalid vendor attribute: %d/%d", resolved.Vendor, resolved.ID)
+	}
+	vsa := make([]byte, 6, 6+len(value))
+	binary.BigEndian.PutUint32(vsa, uint32(resolved.Vendor))
+	vsa[4] = byte(resolved.ID)
+	vsa[5] = byte(2 + len(value))
+	vsa = append(vsa, value...)
+	return fmt.Sprintf("radius_accept_attr=%d:%s:%s", vendorAttr, attrHex, hex.EncodeToString(vsa)), nil
+}
+
+// RenderAttributes converts attributes to hostapd's radius_accept_attr
+func RenderAttributes(attributes []Attribute) ([]string, error) {
+	var result []string
+	for _, a := range attributes {
+		line, err := a.Render()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, line)
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func TestRenderAttribute(t *testing.T) {
+	for attr, expect := range map[Attribute]string{
+		{Name: "Session-Timeout", Value: "3600"}:       "radius_accept_attr=27:d:3600",
+		{Name: "filter-id", Value: "staff"}:            "radius_accept_attr=11:s:staff",
+		{Name: "Class", Value: "abc"}:                  "radius_accept_attr=25:s:abc",
+		{ID: 85, Type: "d", Value: "600"}:              "radius_accept_attr=85:d:600",
+		{ID: 25, Type: "x", Value: "0A0B"}:             "radius_accept_attr=25:x:0a0b",
+		{Name: "Aruba-User-Role", Value: "employee"}:   "radius_accept_attr=26:x:000039e7010a656d706c6f796565",
+		{Name: "Cisco-AVPair", Value: "a=b"}:           "radius_accept_attr=26:x:000000090105613d62",
+		{Vendor: 9, ID: 1, Type: "d", Value: "1"}:      "radius_accept_attr=26:x:00000009010600000001",
+		{Vendor: 14823, ID: 1, Type: "x", Value: "ff"}: "radius_accept_attr=26:x:000039e70103ff",
+		{Name: "Reply-Message", Value: "welcome home"}: "radius_accept_attr=18:s:welcome home",
+	} {
+		line, err := attr.Render()
+		if err != nil || line != expect {
+			t.Errorf("invalid attribute %v: %s (%v)", attr, line, err)
+		}
+	}
+	for _, attr := range []Attribute{
+		{Name: "Unknown", Value: "a"},
+		{Name: "Session-Timeout", Value: "abc"},
+		{Name: "Filter-Id", Value: ""},
+		{Name: "Filter-Id", Value: "a\nradius_accept_attr=81:s:1"},
+		{ID: 81, Type: "s", Value: "1"},
+		{ID: 26, Type: "x", Value: "00"},
+		{ID: 0, Type: "s", Value: "a"},
+		{ID: 256, Type: "s", Value: "a"},
+		{ID: 1, Type: "y", Value: "a"},
+		{ID: 1, Type: "x", Value: "zz"},
+		{Vendor: 9, ID: 1, Type: "s", Value: strings.Repeat("a", 248)},
+	} {
+		if _, err := attr.Render(); err == nil {
+			t.Errorf("invalid attribute: %v", attr)
+		}
+	}
+	lines, err := RenderAttributes([]Attribute{{Name: "Class", Value: "a"}, {Name: "Session-Timeout", Value: "1"}})
+	if err != nil || len(lines) != 2 {
+		t.Error("invalid attributes")
+	}
+	if _, err := RenderAttributes([]Attribute{{Name: "Class", Value: "a"}, {Name: "Class"}}); err == nil {
+		t.Error("invalid attribute")
+	}
+	d := Definition{VLANs: []VLAN{{Name: "a", ID: "1", Attributes: []Attribute{{ID: 64, Type: "d", Value: "1"}}}}}
+	if d.ValidateVLANs() == nil {
+		t.Error("invalid vlan attributes")
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	root := "vlans:\n    - name: abc\n      id: 1\n      attributes:\n        - name: Session-Timeout\n          value: \"3600\"\n    - name: xyz\n      id: 2\n      attributes:\n        - name: Filter-Id\n          value: mab\n"
+	user := "membership:\n    - vlan: abc\nattributes:\n    - name: Aruba-User-Role\n      value: employee\n"
+	for name, text := range map[string]string{vlanConfig: root, filepath.Join("user.name", vlanConfig): user} {
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(text), perms); err != nil {
+			t.Error("unable to write repo")
+		}
+	}
+	b, err := Open(core.ComposeFlags{Repo: repo, Command: []string{"echo", `{"username": "user.name"}`}}, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	defer b.Close()
+	if err := b.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	if err := b.Validate("token", "112233445566"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(repo, bin, "eap_users"))
+	if err != nil {
+		t.Error("not built")
+	}
+	text := string(data)
+	for _, expect := range []string{
+		"radius_accept_attr=81:s:2\nradius_accept_attr=11:s:mab\n",
+		"radius_accept_attr=81:s:1\nradius_accept_attr=27:d:3600\nradius_accept_attr=26:x:000039e7010a656d706c6f796565\n",
+	} {
+		if !strings.Contains(text, expect) {
+			t.Errorf("missing attributes %s:\n%s", expect, text)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(repo, vlanConfig), []byte(strings.Replace(root, "\"3600\"", "never", 1)), perms); err != nil {
+		t.Error("unable to write repo")
+	}
+	if b.Rebuild() == nil {
+		t.Error("invalid vlan attributes")
+	}
+}
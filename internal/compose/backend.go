@@ -0,0 +1,401 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+type (
+	// Backend performs composition operations against a repository with the store kept open
+	Backend struct {
+		flags   core.ComposeFlags
+		timeout time.Duration
+		lock    *sync.RWMutex
+		store   Store
+		source  tokenSource
+		state   string
+	}
+)
+
+// Open prepares a repository for composition (timeout <= 0 is no command timeout)
+func Open(flags core.ComposeFlags, timeout time.Duration) (*Backend, error) {
+	if !core.PathExists(flags.Repo) {
+		return nil, fmt.Errorf("repository invalid/does not exist")
+	}
+	target := filepath.Join(flags.Repo, bin)
+	if !core.PathExists(target) {
+		flags.Debugging("creating target")
+		if err := os.Mkdir(target, 0700); err != nil {
+			return nil, err
+		}
+	}
+	if len(flags.Search) == 0 {
+		flags.Search = []string{"username"}
+	}
+	source, err := newTokenSource(flags)
+	if err != nil {
+		return nil, err
+	}
+	// opening may upgrade the store
+	lock, err := flockRepo(flags.Repo, syscall.LOCK_EX)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	store, err := OpenStore(flags.Store, flags.Repo)
+	if err != nil {
+		return nil, err
+	}
+	b := &Backend{flags: flags, timeout: timeout, lock: &sync.RWMutex{}, store: store, source: source}
+	b.state = b.storeState()
+	return b, nil
+}
+
+// Close closes the underlying store
+func (b *Backend) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.store.Close()
+}
+
+func (b *Backend) wrap(flags core.ComposeFlags) Wrapper {
+	return Wrapper{ComposeFlags: flags, store: b.store, timeout: b.timeout, source: b.source}
+}
+
+// unlocked is for operations that do not use the store
+func (b *Backend) unlocked(flags core.ComposeFlags) Wrapper {
+	return Wrapper{ComposeFlags: flags, timeout: b.timeout, source: b.source}
+}
+
+func (b *Backend) flock(how int) (*os.File, error) {
+	return flockRepo(b.flags.Repo, how)
+}
+
+// flockRepo locks the repository between processes, each call gets its own
+// descriptor so shared and exclusive locks also apply within a process
+func flockRepo(repo string, how int) (*os.File, error) {
+	lock, err := os.OpenFile(filepath.Join(repo, bin, lockFile), os.O_RDWR|os.O_CREATE, perms)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), how); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// storeState identifies the store file as last written, the store is held open
+// so changes by other processes (e.g. a revoke) are only seen by reopening
+func (b *Backend) storeState() string {
+	info, err := os.Stat(storePath(b.flags.Store, b.flags.Repo))
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", info.Size(), info.ModTime().UnixNano())
+}
+
+// reopen opens the store again, callers hold the exclusive lock (opening may upgrade the store)
+func (b *Backend) reopen() error {
+	if err := b.store.Close(); err != nil {
+		return err
+	}
+	store, err := OpenStore(b.flags.Store, b.flags.Repo)
+	if err != nil {
+		return err
+	}
+	b.store = store
+	b.state = b.storeState()
+	return nil
+}
+
+// refresh reopens the store when another process changed it, reopening may
+// upgrade the store so it is exclusive
+func (b *Backend) refresh() error {
+	b.lock.RLock()
+	changed := b.state != b.storeState()
+	b.lock.RUnlock()
+	if !changed {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	lock, err := b.flock(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if b.state == b.storeState() {
+		return nil
+	}
+	b.flags.Debugging("store changed, reopening")
+	return b.reopen()
+}
+
+// shared runs lookups, these may run concurrently
+func (b *Backend) shared(flags core.ComposeFlags, call func(Wrapper) error) error {
+	if err := b.refresh(); err != nil {
+		return err
+	}
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	lock, err := b.flock(syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	return call(b.wrap(flags))
+}
+
+// exclusive runs changes to the store, the store is reopened as other
+// processes may have changed it
+func (b *Backend) exclusive(flags core.ComposeFlags, call func(Wrapper) error) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	lock, err := b.flock(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := b.reopen(); err != nil {
+		return err
+	}
+	// changes made here do not need a reopen
+	defer func() {
+		b.state = b.storeState()
+	}()
+	return call(b.wrap(flags))
+}
+
+func (b *Backend) with(mode string) core.ComposeFlags {
+	flags := b.flags
+	flags.Mode = mode
+	return flags
+}
+
+// MAC checks if a MAC is valid for MAB
+func (b *Backend) MAC(mac string) error {
+	flags := b.with(core.ModeMAC)
+	flags.MAC = mac
+	return b.shared(flags, func(wrapper Wrapper) error {
+		return checkMAC(wrapper, true)
+	})
+}
+
+// TLS checks a user (certificate login) and MAC combination
+func (b *Backend) TLS(user, mac string) error {
+	flags := b.with(core.ModeTLS)
+	flags.User = user
+	flags.MAC = mac
+	return b.shared(flags, checkTLS)
+}
+
+// Validate checks a token+MAC combination, new (or expired) tokens are resolved with the payload command
+func (b *Backend) Validate(token, mac string) error {
+	flags := b.with(core.ModeValidate)
+	flags.Token = token
+	flags.MAC = mac
+	var tokenKey, user string
+	var expired bool
+	if err := b.shared(flags, func(wrapper Wrapper) error {
+		var err error
+		tokenKey, user, expired, err = knownUser(wrapper)
+		return err
+	}); err != nil {
+		return err
+	}
+	if user == "" || expired {
+		// the payload command is not run while locked
+		found, expires, err := tokenUser(b.unlocked(flags))
+		if err != nil {
+			// an unreachable (or failing) source keeps the stored token
+			if expired && isRejected(err) {
+				if err := b.exclusive(flags, func(wrapper Wrapper) error {
+					return revokeToken(wrapper, tokenKey, user)
+				}); err != nil {
+					return err
+				}
+			}
+			return err
+		}
+		if err := b.exclusive(flags, func(wrapper Wrapper) error {
+			if expired && found == user {
+				wrapper.Debugging("token revalidated")
+				if err := validated(wrapper, tokenKey, expires); err != nil {
+					return err
+				}
+				return foundUser(wrapper, user)
+			}
+			return saveUser(wrapper, tokenKey, found, expires)
+		}); err != nil {
+			return err
+		}
+		user = found
+	} else {
+		if err := foundUser(b.unlocked(flags), user); err != nil {
+			return err
+		}
+	}
+	return b.shared(flags, func(wrapper Wrapper) error {
+		return checkUser(wrapper, user)
+	})
+}
+
+// Generation gets the current generation of the store
+func (b *Backend) Generation() (string, error) {
+	var result string
+	err := b.shared(b.with(core.ModeGeneration), func(wrapper Wrapper) error {
+		var err error
+		result, _, err = wrapper.Get(wrapper.NewKey(generation))
+		return err
+	})
+	return result, err
+}
+
+// Revoke removes the tokens of a user, removing the user from the configuration
+func (b *Backend) Revoke(user string) error {
+	flags := b.with(core.ModeRevoke)
+	flags.User = user
+	return b.exclusive(flags, revoke)
+}
+
+// Server sets the server hash, rebuilding when it changes
+func (b *Backend) Server(hash string) error {
+	flags := b.with(core.ModeServer)
+	flags.Hash = hash
+	return b.exclusive(flags, server)
+}
+
+// Fetch gets remote repository changes
+func (b *Backend) Fetch() error {
+	// git performs its own locking
+	return fetch(b.unlocked(b.with(core.ModeFetch)))
+}
+
+// Build rebuilds the configuration if the repository changed
+func (b *Backend) Build() error {
+	return b.exclusive(b.with(core.ModeBuild), func(wrapper Wrapper) error {
+		return build(wrapper, false)
+	})
+}
+
+// Rebuild forces a rebuild of the configuration
+func (b *Backend) Rebuild() error {
+	return b.exclusive(b.with(core.ModeRebuild), func(wrapper Wrapper) error {
+		return build(wrapper, true)
+	})
+}
+
+// Password gets a user's (derived) password, the token is validated to be the user's
+func (b *Backend) Password(user, token string) (string, error) {
+	flags := b.with(core.ModePassword)
+	flags.User = user
+	flags.Token = token
+	// the payload command is not run while locked (the store is not used)
+	return password(b.unlocked(flags))
+}
+
+// Inventory gets the users and MAB devices of the repository
+func (b *Backend) Inventory() (Inventory, error) {
+	var inv Inventory
+	err := b.shared(b.with(core.ModeInventory), func(wrapper Wrapper) error {
+		var err error
+		inv, err = inventory(wrapper)
+		return err
+	})
+	return inv, err
+}
+
+// Migrate copies the store the flags are migrating from into the store
+func (b *Backend) Migrate() error {
+	return b.exclusive(b.with(core.ModeMigrate), func(wrapper Wrapper) error {
+		if storePath(wrapper.From, wrapper.Repo) == storePath(wrapper.Store, wrapper.Repo) {
+			return fmt.Errorf("unable to migrate a store to itself")
+		}
+		from, err := OpenStore(wrapper.From, wrapper.Repo)
+		if err != nil {
+			return err
+		}
+		defer from.Close()
+		count, err := Migrate(from, wrapper.store)
+		if err != nil {
+			return err
+		}
+		wrapper.Debugging(fmt.Sprintf("migrated %d keys", count))
+		return nil
+	})
+}
+
+// Run performs the mode of the flags the backend was opened with
+func (b *Backend) Run() error {
+	flags := b.flags
+	switch flags.Mode {
+	case core.ModeValidate:
+		if (len(flags.Command) == 0 && b.source == nil) || len(flags.Token) == 0 || len(flags.MAC) == 0 {
+			return fmt.Errorf("missing flags for validation")
+		}
+		return b.Validate(flags.Token, flags.MAC)
+	case core.ModeTLS:
+		if len(flags.User) == 0 || len(flags.MAC) == 0 {
+			return fmt.Errorf("missing flags for tls")
+		}
+		return b.TLS(flags.User, flags.MAC)
+	case core.ModeServer:
+		if len(flags.Hash) == 0 {
+			return fmt.Errorf("missing flags for server")
+		}
+		return b.Server(flags.Hash)
+	case core.ModeMAC:
+		if len(flags.MAC) == 0 {
+			return fmt.Errorf("missing flags for mac")
+		}
+		return b.MAC(flags.MAC)
+	case core.ModeFetch:
+		return b.Fetch()
+	case core.ModeBuild:
+		return b.Build()
+	case core.ModeRebuild:
+		return b.Rebuild()
+	case core.ModeRevoke:
+		if len(flags.User) == 0 {
+			return fmt.Errorf("missing flags for revoke")
+		}
+		return b.Revoke(flags.User)
+	case core.ModePassword:
+		if len(flags.User) == 0 || len(flags.Token) == 0 {
+			return fmt.Errorf("missing flags for password")
+		}
+		result, err := b.Password(flags.User, flags.Token)
+		if err != nil {
+			return err
+		}
+		fmt.Println(result)
+		return nil
+	case core.ModeInventory:
+		inv, err := b.Inventory()
+		if err != nil {
+			return err
+		}
+		return inv.Write(os.Stdout, flags.Format)
+	case core.ModeMigrate:
+		if len(flags.From.Type) == 0 {
+			return fmt.Errorf("missing flags for migrate")
+		}
+		return b.Migrate()
+	case core.ModeGeneration:
+		result, err := b.Generation()
+		if err != nil {
+			return err
+		}
+		fmt.Println(result)
+		return nil
+	default:
+		return fmt.Errorf("unknown mode")
+	}
+}
@@ -0,0 +1,257 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func writeRepo(t *testing.T, repo string) {
+	files := map[string]string{
+		vlanConfig:                                 "vlans:\n    - name: abc\n      id: 1\n    - name: xyz\n      id: 2\n",
+		filepath.Join("user.name", vlanConfig):     "membership:\n    - vlan: abc\n",
+		filepath.Join("user.name", "112233445566"): "",
+		filepath.Join("xyz", "aabbccddeeff"):       "",
+	}
+	for name, text := range files {
+		path := filepath.Join(repo, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Error("unable to create repo")
+		}
+		if err := os.WriteFile(path, []byte(text), perms); err != nil {
+			t.Error("unable to write repo")
+		}
+	}
+}
+
+func TestBackend(t *testing.T) {
+	repo := t.TempDir()
+	if _, err := Open(core.ComposeFlags{Repo: filepath.Join(repo, "missing")}, 0); err == nil {
+		t.Error("repository should not exist")
+	}
+	writeRepo(t, repo)
+	flags := core.ComposeFlags{Repo: repo, Command: []string{"echo", `{"username": "user.name"}`}}
+	b, err := Open(flags, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	if err := b.Run(); err == nil {
+		t.Error("no mode")
+	}
+	if err := b.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	if !core.PathExists(filepath.Join(repo, bin, "eap_users")) {
+		t.Error("not built")
+	}
+	if b.MAC("aabbccddeeff") != nil || b.MAC("112233445566") == nil || b.MAC("xyz") == nil {
+		t.Error("invalid mab")
+	}
+	if err := b.Validate("token", "112233445566"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	if b.Validate("token", "aabbccddeeff") == nil {
+		t.Error("not a user MAC")
+	}
+	if err := b.Close(); err != nil {
+		t.Error("unable to close")
+	}
+	flags.Command = []string{"false"}
+	b, err = Open(flags, 0)
+	if err != nil {
+		t.Errorf("unable to reopen: %v", err)
+		return
+	}
+	defer b.Close()
+	if err := b.Validate("token", "112233445566"); err != nil {
+		t.Errorf("token should be stored: %v", err)
+	}
+	if b.Validate("other", "112233445566") == nil {
+		t.Error("command should fail")
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	flags := core.ComposeFlags{Repo: repo, Revalidate: 60, Command: []string{"echo", `{"username": "user.name"}`}}
+	b, err := Open(flags, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	defer b.Close()
+	if err := b.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	hasUser := func() bool {
+		data, err := os.ReadFile(filepath.Join(repo, bin, "eap_users"))
+		if err != nil {
+			t.Error("not built")
+		}
+		return strings.Contains(string(data), "user.name:")
+	}
+	if err := b.Validate("token", "112233445566"); err != nil || !hasUser() {
+		t.Errorf("should validate: %v", err)
+	}
+	hash, _ := core.MD4("token")
+	expired := validatedKey(Wrapper{}.NewKey(hash))
+	if b.store.Save(expired, "0") != nil {
+		t.Error("unable to expire")
+	}
+	if err := b.Validate("token", "112233445566"); err != nil {
+		t.Errorf("should revalidate: %v", err)
+	}
+	if val, _, _ := b.store.Get(expired); val == "0" {
+		t.Error("not revalidated")
+	}
+	b.flags.Command = []string{"false"}
+	if err := b.Validate("token", "112233445566"); err != nil {
+		t.Errorf("not expired: %v", err)
+	}
+	if b.store.Save(expired, "0") != nil {
+		t.Error("unable to expire")
+	}
+	if b.Validate("token", "112233445566") == nil || !hasUser() {
+		t.Error("failing source should keep the token")
+	}
+	b.flags.Command = []string{"echo", `{}`}
+	if b.Validate("token", "112233445566") == nil || hasUser() {
+		t.Error("token should be revoked")
+	}
+	if _, ok, _ := b.store.Get(expired); ok {
+		t.Error("validation time not removed")
+	}
+	b.flags.Command = []string{"echo", `{"username": "user.name"}`}
+	if err := b.Validate("token", "112233445566"); err != nil || !hasUser() {
+		t.Errorf("should validate: %v", err)
+	}
+	b.flags.Mode = core.ModeRevoke
+	if b.Run() == nil {
+		t.Error("no user to revoke")
+	}
+	if err := b.Revoke("user.name"); err != nil || hasUser() {
+		t.Errorf("user should be revoked: %v", err)
+	}
+	if b.Revoke("user.name") == nil {
+		t.Error("unknown user")
+	}
+	b.flags.Command = []string{"false"}
+	if b.Validate("token", "112233445566") == nil {
+		t.Error("token should not be known")
+	}
+}
+
+func TestSharedStore(t *testing.T) {
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	flags := core.ComposeFlags{Repo: repo, Command: []string{"echo", `{"username": "user.name"}`}}
+	runner, err := Open(flags, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	defer runner.Close()
+	if err := runner.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	if err := runner.Validate("token", "112233445566"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	gen, err := runner.Generation()
+	if err != nil || gen == "" {
+		t.Errorf("no generation: %v", err)
+	}
+	// another process (e.g. the CLI) revokes the user
+	cli, err := Open(flags, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	if err := cli.Revoke("user.name"); err != nil {
+		t.Errorf("should revoke: %v", err)
+	}
+	cli.Close()
+	if changed, err := runner.Generation(); err != nil || changed == gen {
+		t.Errorf("generation should change: %s (%v)", changed, err)
+	}
+	inv, err := runner.Inventory()
+	if err != nil {
+		t.Errorf("no inventory: %v", err)
+	}
+	for _, u := range inv.Users {
+		if u.Name == "user.name" && u.Token {
+			t.Error("revoked token still known")
+		}
+	}
+	runner.flags.Command = []string{"false"}
+	if runner.Validate("token", "112233445566") == nil {
+		t.Error("token should not be known")
+	}
+}
+
+func TestTLS(t *testing.T) {
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	files := map[string]string{
+		vlanConfig:                                 "vlans:\n    - name: abc\n      id: 1\n    - name: xyz\n      id: 2\n      tls: true\n",
+		filepath.Join("cert.name", vlanConfig):     "membership:\n    - vlan: abc\n    - vlan: xyz\n",
+		filepath.Join("cert.name", "aabbccdd1122"): "",
+		filepath.Join("all.name", vlanConfig):      "tls: true\nmembership:\n    - vlan: abc\n",
+		filepath.Join("all.name", "aabbccdd3344"):  "",
+	}
+	for name, text := range files {
+		path := filepath.Join(repo, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Error("unable to create repo")
+		}
+		if err := os.WriteFile(path, []byte(text), perms); err != nil {
+			t.Error("unable to write repo")
+		}
+	}
+	b, err := Open(core.ComposeFlags{Repo: repo, Command: []string{"echo", `{"username": "cert.name"}`}}, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	defer b.Close()
+	if err := b.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(repo, bin, "eap_users"))
+	if err != nil {
+		t.Error("not built")
+	}
+	text := string(data)
+	for _, login := range []string{`"cert.name" TLS`, `"cert.name@vlan.xyz" TLS`, `"all.name" TLS`, `"all.name@vlan.abc" TLS`} {
+		if !strings.Contains(text, login) {
+			t.Errorf("missing %s:\n%s", login, text)
+		}
+	}
+	if strings.Contains(text, "cert.name@vlan.abc") || strings.Contains(text, "user.name") {
+		t.Errorf("users without tokens:\n%s", text)
+	}
+	if err := b.TLS("cert.name", "aabbccdd1122"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	if err := b.TLS("all.name", "aa:bb:cc:dd:33:44"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	if b.TLS("cert.name", "aabbccdd3344") == nil || b.TLS("user.name", "112233445566") == nil {
+		t.Error("invalid tls user/mac")
+	}
+	if b.TLS("..", "112233445566") == nil || b.TLS("other.name", "112233445566") == nil {
+		t.Error("unknown user")
+	}
+	if err := b.Validate("token", "aabbccdd1122"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	data, _ = os.ReadFile(filepath.Join(repo, bin, "eap_users"))
+	if !strings.Contains(string(data), `"cert.name:token@vlan.abc" PEAP`) || strings.Contains(string(data), "cert.name:token@vlan.xyz") {
+		t.Errorf("token logins are not tls:\n%s", data)
+	}
+}
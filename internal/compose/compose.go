@@ -1,28 +1,20 @@
 package compose
 
 import (
-	"encoding/json"
 	"fmt"
-	"strconv"
-	"strings"
+	"time"
 
-	"github.com/tidwall/buntdb"
 	"voidedtech.com/dotonex/internal/core"
 )
 
-const (
-	inArrayPre  = "inarray["
-	inArrayPost = "]"
-)
-
 type (
-	// GetUser is a callback to verify if a user is valid within the backend system
-	GetUser func(string) bool
-
 	// VLAN for composing vlan definitions
 	VLAN struct {
-		Name string
-		ID   string
+		Name       string
+		ID         string
+		Groups     []string
+		TLS        bool
+		Attributes []Attribute
 	}
 	// Member indicates something is a member of a VLAN
 	Member struct {
@@ -32,52 +24,43 @@ type (
 	Definition struct {
 		VLANs      []VLAN
 		Membership []Member
+		TLS        bool
+		Method     string
+		Attributes []Attribute
 	}
 
-	// Store is backend handling of data
-	Store struct {
+	// Wrapper is the state of a composition operation (flags and the store)
+	Wrapper struct {
 		core.ComposeFlags
-		db *buntdb.DB
+		store   Store
+		timeout time.Duration
+		source  tokenSource
 	}
 )
 
 // Get will get a store value
-func (s Store) Get(key string) (string, bool, error) {
-	var val string
-	rErr := s.db.View(func(tx *buntdb.Tx) error {
-		var err error
-		val, err = tx.Get(key)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
-	if rErr == nil {
-		return val, true, nil
-	}
-	if rErr == buntdb.ErrNotFound {
-		return "", false, nil
-	}
-	return "", false, rErr
+func (w Wrapper) Get(key string) (string, bool, error) {
+	return w.store.Get(key)
 }
 
 // Save commits a value to the store
-func (s Store) Save(key, value string) error {
-	err := s.db.Update(func(tx *buntdb.Tx) error {
-		_, _, err := tx.Set(key, value, nil)
-		return err
-	})
-	return err
+func (w Wrapper) Save(key, value string) error {
+	return w.store.Save(key, value)
+}
+
+// Delete removes a store value
+func (w Wrapper) Delete(key string) error {
+	return w.store.Delete(key)
 }
 
 // NewKey creates a database key
-func (s Store) NewKey(name string) string {
+func (w Wrapper) NewKey(name string) string {
 	return fmt.Sprintf("root=>%s", name)
 }
 
-// NewStore initializes a storage backend
-func NewStore(flags core.ComposeFlags, db *buntdb.DB) Store {
-	return Store{ComposeFlags: flags, db: db}
+// NewWrapper initializes the state of an operation
+func NewWrapper(flags core.ComposeFlags, store Store) Wrapper {
+	return Wrapper{ComposeFlags: flags, store: store}
 }
 
 // ValidateMembership will check if membership settings are valid
@@ -102,93 +85,52 @@ func (d Definition) ValidateVLANs() error {
 		if v.Name == "" || v.ID == "" {
 			return fmt.Errorf("invalid vlan")
 		}
+		if _, err := RenderAttributes(v.Attributes); err != nil {
+			return fmt.Errorf("invalid vlan attributes (%s): %v", v.Name, err)
+		}
 	}
 	return nil
 }
 
-// IsVLAN gets and checks if a vlan is valid in the definition
-func (d Definition) IsVLAN(name string) (string, bool) {
+// FindVLAN gets a vlan by name
+func (d Definition) FindVLAN(name string) (VLAN, bool) {
 	for _, v := range d.VLANs {
 		if v.Name == name {
-			return v.ID, true
+			return v, true
 		}
 	}
-	return "", false
+	return VLAN{}, false
+}
+
+// HasTLS checks if any vlan uses TLS (certificate) logins
+func (d Definition) HasTLS() bool {
+	for _, v := range d.VLANs {
+		if v.TLS {
+			return true
+		}
+	}
+	return false
 }
 
-// TryGetUser will try and find a user in json output and validate it
-func TryGetUser(layout []string, data []byte, verify GetUser) (string, error) {
-	var errors []error
-	for idx, l := range layout {
-		next := layout[idx+1:]
-		isArray := strings.HasPrefix(l, inArrayPre) && strings.HasSuffix(l, inArrayPost)
-		if isArray {
-			indexer := strings.Replace(l, inArrayPre, "", 1)
-			indexer = strings.TrimSpace(indexer[0 : len(indexer)-1])
-			useIndex := -1
-			if indexer != "" {
-				i, err := strconv.Atoi(indexer)
-				if err != nil {
-					return "", err
-				}
-				useIndex = i
-			}
-			var obj []interface{}
-			if err := json.Unmarshal(data, &obj); err != nil {
-				return "", err
-			}
-			if len(obj) == 0 {
-				return "", fmt.Errorf("zero array found")
-			}
-			for subIdx, sub := range obj {
-				if useIndex >= 0 {
-					if subIdx != useIndex {
-						continue
-					}
-				}
-				b, err := json.Marshal(sub)
-				if err != nil {
-					return "", err
-				}
-				found, err := TryGetUser(next, b, verify)
-				if found != "" {
-					return found, nil
-				}
-				errors = append(errors, err)
-			}
-		} else {
-			m := make(map[string]interface{})
-			if err := json.Unmarshal(data, &m); err != nil {
-				return "", err
-			}
-			sub, ok := m[l]
-			if !ok {
-				return "", fmt.Errorf("subkey not found")
-			}
-			user, ok := sub.(string)
-			if ok {
-				if user == "" {
-					return "", fmt.Errorf("empty string found")
-				}
-				return user, nil
-			}
-			b, err := json.Marshal(sub)
-			if err != nil {
-				return "", err
-			}
-			found, err := TryGetUser(next, b, verify)
-			if found != "" {
-				return found, nil
-			}
-			errors = append(errors, err)
+// UsesTLS checks if a user definition has TLS (certificate) logins for any membership
+func (d Definition) UsesTLS(vlans Definition) bool {
+	if d.TLS {
+		return true
+	}
+	for _, m := range d.Membership {
+		if v, ok := vlans.FindVLAN(m.VLAN); ok && v.TLS {
+			return true
 		}
 	}
-	if len(errors) > 0 {
-		var multi []string
-		for _, e := range errors {
-			multi = append(multi, e.Error())
+	return false
+}
+
+// IsVLAN gets and checks if a vlan is valid in the definition
+func (d Definition) IsVLAN(name string) (string, bool) {
+	for _, v := range d.VLANs {
+		if v.Name == name {
+			return v.ID, true
 		}
-		return "", fmt.Errorf(strings.Join(multi, "\n"))
 	}
-	return "", fmt.Errorf("unable to find a user")
+	return "", false
 }
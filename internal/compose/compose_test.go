@@ -4,23 +4,23 @@ import (
 	"testing"
 
 	"github.com/tidwall/buntdb"
+	"voidedtech.com/dotonex/internal/core"
 )
 
 func TestNewKey(t *testing.T) {
-	s := Store{}
+	s := Wrapper{}
 	if "root=>key" != s.NewKey("key") {
 		t.Error("invalid key")
 	}
 }
 
 func TestSaveGet(t *testing.T) {
-	s := Store{}
 	db, err := buntdb.Open(":memory:")
 	if err != nil {
 		t.Error("memory db failed")
 	}
 	defer db.Close()
-	s.db = db
+	s := NewWrapper(core.ComposeFlags{}, buntStore{db: db})
 	val, ok, err := s.Get("TEST")
 	if ok || err != nil || val != "" {
 		t.Error("not found")
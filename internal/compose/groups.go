@@ -0,0 +1,176 @@
+package compose
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+type (
+	// groupDirectory gets the groups of users from an LDAP server
+	groupDirectory struct {
+		cfg  core.GroupLDAP
+		conn *ldap.Conn
+	}
+
+	// directoryGroups are the groups of every user in the directory (by lower case user name)
+	directoryGroups struct {
+		users      map[string][]string
+		duplicates map[string]bool
+	}
+)
+
+const (
+	ldapPageSize = 500
+)
+
+func dialGroups(cfg core.GroupLDAP) (*groupDirectory, error) {
+	if cfg.BaseDN == "" {
+		return nil, fmt.Errorf("no ldap base dn")
+	}
+	if cfg.Filter == "" {
+		cfg.Filter = "(uid=%s)"
+	}
+	if cfg.Name == "" {
+		cfg.Name = "uid"
+	}
+	if cfg.Attribute == "" {
+		cfg.Attribute = "memberOf"
+	}
+	tlsConfig := &tls.Config{}
+	if cfg.CA != "" {
+		b, err := os.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found: %s", cfg.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	conn, err := ldap.DialURL(cfg.URL, ldap.DialWithTLSDialer(tlsConfig, &net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetTimeout(timeout)
+	}
+	if cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if cfg.BindDN != "" {
+		if err := conn.Bind(cfg.BindDN, cfg.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &groupDirectory{cfg: cfg, conn: conn}, nil
+}
+
+func (g *groupDirectory) close() {
+	g.conn.Close()
+}
+
+// all gets the groups of every user with one search (a build would otherwise search once per user)
+func (g *groupDirectory) all() (*directoryGroups, error) {
+	req := ldap.NewSearchRequest(g.cfg.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		g.cfg.Timeout,
+		false,
+		fmt.Sprintf(g.cfg.Filter, "*"),
+		[]string{g.cfg.Name, g.cfg.Attribute},
+		nil)
+	result, err := g.conn.SearchWithPaging(req, ldapPageSize)
+	if err != nil {
+		return nil, err
+	}
+	found := &directoryGroups{users: make(map[string][]string), duplicates: make(map[string]bool)}
+	for _, entry := range result.Entries {
+		groups := entry.GetEqualFoldAttributeValues(g.cfg.Attribute)
+		for _, name := range entry.GetEqualFoldAttributeValues(g.cfg.Name) {
+			user := strings.ToLower(name)
+			if _, ok := found.users[user]; ok {
+				found.duplicates[user] = true
+			}
+			found.users[user] = groups
+		}
+	}
+	return found, nil
+}
+
+// groups gets the groups of a user (none if the user is not in the directory)
+func (d *directoryGroups) groups(user string) ([]string, error) {
+	key := strings.ToLower(user)
+	if d.duplicates[key] {
+		return nil, fmt.Errorf("multiple ldap entries for %s", user)
+	}
+	return d.users[key], nil
+}
+
+// groupName gets the common name of a group DN (or the group as given)
+func groupName(group string) string {
+	dn, err := ldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 {
+		return group
+	}
+	for _, attr := range dn.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, "cn") {
+			return attr.Value
+		}
+	}
+	return group
+}
+
+// GroupMembership maps groups (by DN or common name) to VLANs with those groups
+func (d Definition) GroupMembership(groups []string) []Member {
+	var members []Member
+	for _, v := range d.VLANs {
+		found := false
+		for _, group := range groups {
+			name := groupName(group)
+			for _, g := range v.Groups {
+				if strings.EqualFold(g, group) || strings.EqualFold(g, name) {
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if found {
+			members = append(members, Member{VLAN: v.Name})
+		}
+	}
+	return members
+}
+
+// MergeMembership adds members that are not already members
+func (d *Definition) MergeMembership(members []Member) {
+	for _, m := range members {
+		exists := false
+		for _, existing := range d.Membership {
+			if existing.VLAN == m.VLAN {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			d.Membership = append(d.Membership, m)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package compose
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+type (
+	ldapStub struct {
+		listener net.Listener
+		entries  []ldapEntry
+		lock     *sync.Mutex
+		searches int
+	}
+
+	ldapEntry struct {
+		user   string
+		groups []string
+	}
+)
+
+func newLDAPStub(t *testing.T, entries []ldapEntry) *ldapStub {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("unable to listen: %v", err)
+		return nil
+	}
+	stub := &ldapStub{listener: l, entries: entries, lock: &sync.Mutex{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go stub.serve(conn)
+		}
+	}()
+	return stub
+}
+
+func (s *ldapStub) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func ldapMessage(id int64, tag ber.Tag, children ...*ber.Packet) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	for _, c := range children {
+		op.AppendChild(c)
+	}
+	p.AppendChild(op)
+	return p
+}
+
+func ldapAttribute(name string, values ...string) *ber.Packet {
+	set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+	for _, v := range values {
+		set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+	}
+	attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+	attr.AppendChild(set)
+	return attr
+}
+
+func (s *ldapStub) searched() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.searches
+}
+
+func ldapResult(code int64) []*ber.Packet {
+	return []*ber.Packet{
+		ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""),
+		ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""),
+		ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""),
+	}
+}
+
+func (s *ldapStub) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id := p.Children[0].Value.(int64)
+		op := p.Children[1]
+		var responses []*ber.Packet
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := int64(ldap.LDAPResultSuccess)
+			if op.Children[2].Data.String() != "secret" {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			responses = append(responses, ldapMessage(id, ldap.ApplicationBindResponse, ldapResult(code)...))
+		case ldap.ApplicationSearchRequest:
+			s.lock.Lock()
+			s.searches++
+			s.lock.Unlock()
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			user := strings.TrimSuffix(strings.TrimPrefix(filter, "(uid="), ")")
+			for _, e := range s.entries {
+				if user != "*" && user != e.user {
+					continue
+				}
+				attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				attrs.AppendChild(ldapAttribute("uid", e.user))
+				attrs.AppendChild(ldapAttribute("memberOf", e.groups...))
+				dn := ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "uid="+e.user+",dc=example", "")
+				responses = append(responses, ldapMessage(id, ldap.ApplicationSearchResultEntry, dn, attrs))
+			}
+			responses = append(responses, ldapMessage(id, ldap.ApplicationSearchResultDone, ldapResult(ldap.LDAPResultSuccess)...))
+		default:
+			return
+		}
+		for _, r := range responses {
+			if _, err := conn.Write(r.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestGroupMembership(t *testing.T) {
+	d := Definition{VLANs: []VLAN{
+		{Name: "abc", ID: "1", Groups: []string{"network"}},
+		{Name: "xyz", ID: "2", Groups: []string{"cn=admins,ou=groups,dc=example"}},
+		{Name: "none", ID: "3"},
+	}}
+	if len(d.GroupMembership(nil)) != 0 {
+		t.Error("no groups")
+	}
+	members := d.GroupMembership([]string{"CN=Network,ou=groups,dc=example", "cn=admins,ou=groups,dc=example", "other"})
+	if len(members) != 2 || members[0].VLAN != "abc" || members[1].VLAN != "xyz" {
+		t.Errorf("invalid membership: %v", members)
+	}
+	d.Membership = []Member{{VLAN: "abc"}}
+	d.MergeMembership(members)
+	if len(d.Membership) != 2 {
+		t.Errorf("invalid merge: %v", d.Membership)
+	}
+	if groupName("cn=a,dc=b") != "a" || groupName("uid=a,dc=b") != "uid=a,dc=b" || groupName("a") != "a" {
+		t.Error("invalid group name")
+	}
+}
+
+func TestLDAPGroups(t *testing.T) {
+	stub := newLDAPStub(t, []ldapEntry{
+		{user: "user.name", groups: []string{"cn=xyz-users,dc=example"}},
+		{user: "dup.name", groups: []string{"cn=xyz-users,dc=example"}},
+		{user: "dup.name"},
+	})
+	if stub == nil {
+		return
+	}
+	defer stub.listener.Close()
+	cfg := core.GroupLDAP{URL: stub.url(), BaseDN: "dc=example", BindDN: "cn=dotonex", Password: "wrong"}
+	if _, err := dialGroups(cfg); err == nil {
+		t.Error("invalid bind")
+	}
+	cfg.Password = "secret"
+	g, err := dialGroups(cfg)
+	if err != nil {
+		t.Errorf("unable to dial: %v", err)
+		return
+	}
+	all, err := g.all()
+	g.close()
+	if err != nil {
+		t.Errorf("unable to search: %v", err)
+		return
+	}
+	groups, err := all.groups("User.Name")
+	if err != nil || len(groups) != 1 {
+		t.Errorf("invalid groups: %v %v", groups, err)
+	}
+	groups, err = all.groups("other.name")
+	if err != nil || len(groups) != 0 {
+		t.Errorf("no groups: %v %v", groups, err)
+	}
+	if _, err := all.groups("dup.name"); err == nil {
+		t.Error("multiple entries")
+	}
+
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	root := "vlans:\n    - name: abc\n      id: 1\n    - name: xyz\n      id: 2\n      groups: [\"xyz-users\"]\n"
+	if err := os.WriteFile(filepath.Join(repo, vlanConfig), []byte(root), perms); err != nil {
+		t.Error("unable to write vlans")
+	}
+	// certificate users are built without a token
+	if err := os.MkdirAll(filepath.Join(repo, "dup.name"), 0700); err != nil {
+		t.Error("unable to create user")
+	}
+	if err := os.WriteFile(filepath.Join(repo, "dup.name", vlanConfig), []byte("tls: true\nmembership:\n    - vlan: abc\n"), perms); err != nil {
+		t.Error("unable to write user")
+	}
+	for _, override := range []bool{false, true} {
+		cfg.Override = override
+		b, err := Open(core.ComposeFlags{Repo: repo, LDAP: cfg, Command: []string{"echo", `{"username": "user.name"}`}}, 0)
+		if err != nil {
+			t.Errorf("unable to open: %v", err)
+			return
+		}
+		if err := b.Server("hash"); err != nil {
+			t.Errorf("server failed: %v", err)
+		}
+		if err := b.Validate("token", "112233445566"); err != nil {
+			t.Errorf("should validate: %v", err)
+		}
+		searches := stub.searched()
+		if err := b.Rebuild(); err != nil {
+			t.Errorf("rebuild failed: %v", err)
+		}
+		if stub.searched() != searches+1 {
+			t.Error("one search per build")
+		}
+		data, err := os.ReadFile(filepath.Join(repo, bin, "eap_users"))
+		if err != nil {
+			t.Error("not built")
+		}
+		text := string(data)
+		hasXYZ := strings.Contains(text, "user.name:token@vlan.xyz\"")
+		hasABC := strings.Contains(text, "user.name:token@vlan.abc\"")
+		if !hasXYZ || hasABC == override {
+			t.Errorf("invalid membership (override: %v):\n%s", override, text)
+		}
+		if !strings.Contains(text, `"dup.name" TLS`) || strings.Contains(text, `"dup.name@vlan.xyz" TLS`) {
+			t.Errorf("repository membership expected:\n%s", text)
+		}
+		b.Close()
+	}
+}
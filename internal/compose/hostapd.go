@@ -3,6 +3,8 @@ package compose
 import (
 	"fmt"
 	"strings"
+
+	"voidedtech.com/dotonex/internal/core"
 )
 
 type (
@@ -12,6 +14,10 @@ type (
 		password string
 		vlan     string
 		mab      bool
+		tls      bool
+		method   string
+		extra    []string
+		macs     []string
 	}
 )
 
@@ -22,18 +28,63 @@ radius_accept_attr=65:d:6
 radius_accept_attr=81:s:%s`
 
 	mabLogin  = `"%s" MD5 "%s"` + attributes
-	userLogin = `"%s" PEAP
+	userLogin = `"%s" %s
 
-"%s" MSCHAPV2 hash:%s [2]` + attributes
+"%s" %s %s [2]` + attributes
+	tlsLogin = `"%s" TLS` + attributes
 )
 
 // String
 func (h Hostapd) String() string {
+	text := h.login()
+	if len(h.extra) > 0 {
+		text = fmt.Sprintf("%s\n%s", text, strings.Join(h.extra, "\n"))
+	}
+	return text
+}
+
+// With adds (rendered) reply attributes to a login
+func (h Hostapd) With(attributes []string) Hostapd {
+	h.extra = append(append([]string{}, h.extra...), attributes...)
+	return h
+}
+
+// Using sets the MACs that may use a login (MAB logins are their own MAC)
+func (h Hostapd) Using(macs []string) Hostapd {
+	h.macs = macs
+	return h
+}
+
+func (h Hostapd) assignment() Assignment {
+	if h.mab {
+		return Assignment{Login: strings.ToUpper(h.name), VLAN: h.vlan, MACs: []string{h.name}}
+	}
+	macs := h.macs
+	if macs == nil {
+		macs = []string{}
+	}
+	return Assignment{Login: h.name, VLAN: h.vlan, MACs: macs}
+}
+
+func (h Hostapd) login() string {
 	if h.mab {
 		upper := strings.ToUpper(h.name)
 		return fmt.Sprintf(mabLogin, upper, upper, h.vlan)
 	}
-	return fmt.Sprintf(userLogin, h.name, h.name, h.password, h.vlan)
+	if h.tls {
+		return fmt.Sprintf(tlsLogin, h.name, h.vlan)
+	}
+	phase1, phase2 := "PEAP", "MSCHAPV2"
+	password := fmt.Sprintf("hash:%s", h.password)
+	switch h.method {
+	case core.MethodTTLSMSCHAPv2:
+		phase1, phase2 = "TTLS", "TTLS-MSCHAPV2"
+	case core.MethodTTLSPAP:
+		// PAP is checked against the password (not a hash)
+		phase1, phase2 = "TTLS", "TTLS-PAP"
+		password = fmt.Sprintf(`"%s"`, h.password)
+	}
+	return fmt.Sprintf(userLogin, h.name, phase1, h.name, phase2, password, h.vlan)
 }
 
 // NewHostapd generates a new hostapd configuration setup
@@ -41,3 +92,23 @@ func NewHostapd(name, password, vlanID string) Hostapd {
 	mab := name == password
 	return Hostapd{name: name, password: password, vlan: vlanID, mab: mab}
 }
+
+// NewMethodHostapd generates a user login for inner methods (the password is a hash
+// except for PAP)
+func NewMethodHostapd(name, method, password, vlanID string) Hostapd {
+	return Hostapd{name: name, password: password, vlan: vlanID, method: method}
+}
+
+// NewTLSHostapd generates a certificate (EAP-TLS) login for an identity
+func NewTLSHostapd(identity, vlanID string) Hostapd {
+	return Hostapd{name: identity, vlan: vlanID, tls: true}
+}
+
+// ValidMethod checks if a user login method is known
+func ValidMethod(method string) bool {
+	switch method {
+	case "", core.MethodPEAP, core.MethodTTLSMSCHAPv2, core.MethodTTLSPAP:
+		return true
+	}
+	return false
+}
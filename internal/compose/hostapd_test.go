@@ -1,7 +1,10 @@
 package compose
 
 import (
+	"strings"
 	"testing"
+
+	"voidedtech.com/dotonex/internal/core"
 )
 
 func TestMABString(t *testing.T) {
@@ -25,3 +28,37 @@ radius_accept_attr=81:s:123` {
 		t.Error("invalid user string")
 	}
 }
+
+func TestTLSString(t *testing.T) {
+	h := NewTLSHostapd("test@vlan.abc", "123")
+	if h.String() != `"test@vlan.abc" TLS
+radius_accept_attr=64:d:13
+radius_accept_attr=65:d:6
+radius_accept_attr=81:s:123` {
+		t.Error("invalid tls string")
+	}
+}
+
+func TestMethodString(t *testing.T) {
+	h := NewMethodHostapd("test", core.MethodTTLSPAP, "secret", "123")
+	if h.String() != `"test" TTLS
+
+"test" TTLS-PAP "secret" [2]
+radius_accept_attr=64:d:13
+radius_accept_attr=65:d:6
+radius_accept_attr=81:s:123` {
+		t.Error("invalid ttls-pap string")
+	}
+	h = NewMethodHostapd("test", core.MethodTTLSMSCHAPv2, "atest", "123")
+	if !strings.HasPrefix(h.String(), `"test" TTLS
+
+"test" TTLS-MSCHAPV2 hash:atest [2]`) {
+		t.Error("invalid ttls-mschapv2 string")
+	}
+	if NewMethodHostapd("test", core.MethodPEAP, "atest", "123").String() != NewHostapd("test", "atest", "123").String() {
+		t.Error("peap is the default")
+	}
+	if !ValidMethod("") || !ValidMethod(core.MethodTTLSPAP) || ValidMethod("other") {
+		t.Error("invalid methods")
+	}
+}
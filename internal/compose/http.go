@@ -0,0 +1,170 @@
+package compose
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const (
+	tokenFormat  = "%s"
+	maxTokenBody = 1024 * 1024
+)
+
+type (
+	// tokenSource gets the response describing the user of a token
+	tokenSource interface {
+		fetch(wrapper Wrapper) (string, error)
+		// layouts are the searches for the user within the response
+		layouts(search []string) [][]string
+		// expires gets when (unix time) the token of a response expires, 0 when it does not
+		expires(body string) int64
+	}
+
+	// tokenClient validates tokens against an HTTP endpoint, the response is searched for the user
+	tokenClient struct {
+		cfg    core.TokenHTTP
+		name   string
+		value  string
+		client *http.Client
+	}
+)
+
+func newTokenClient(cfg core.TokenHTTP) (*tokenClient, error) {
+	if _, err := url.Parse(strings.ReplaceAll(cfg.URL, tokenFormat, "token")); err != nil {
+		return nil, err
+	}
+	c := &tokenClient{cfg: cfg}
+	placed := strings.Contains(cfg.URL, tokenFormat) || cfg.Query != ""
+	if cfg.Header != "" {
+		parts := strings.SplitN(cfg.Header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid header: %s", cfg.Header)
+		}
+		c.name = strings.TrimSpace(parts[0])
+		c.value = strings.TrimSpace(parts[1])
+		placed = placed || strings.Contains(c.value, tokenFormat)
+	}
+	if !placed {
+		return nil, fmt.Errorf("no token placement (url, query, or header)")
+	}
+	if len(c.cfg.Status) == 0 {
+		c.cfg.Status = []int{http.StatusOK}
+	}
+	client, err := newHTTPClient(cfg.CA, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.client = client
+	return c, nil
+}
+
+// newHTTPClient creates a client trusting a CA bundle (system authorities when empty)
+func newHTTPClient(ca string, timeout int) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ca != "" {
+		b, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found: %s", ca)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport, Timeout: time.Duration(timeout) * time.Second}, nil
+}
+
+func (c *tokenClient) layouts(search []string) [][]string {
+	return [][]string{search}
+}
+
+func (c *tokenClient) expires(body string) int64 {
+	return 0
+}
+
+// tokenURL places a token in a URL, escaped for the path or the query it is placed in
+func tokenURL(raw, token string) string {
+	path, query := raw, ""
+	if idx := strings.Index(raw, "?"); idx >= 0 {
+		path, query = raw[:idx], raw[idx:]
+	}
+	return strings.ReplaceAll(path, tokenFormat, url.PathEscape(token)) + strings.ReplaceAll(query, tokenFormat, url.QueryEscape(token))
+}
+
+func (c *tokenClient) request(token string) (*http.Request, error) {
+	u, err := url.Parse(tokenURL(c.cfg.URL, token))
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.Query != "" {
+		query := u.Query()
+		query.Set(c.cfg.Query, token)
+		u.RawQuery = query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.name != "" {
+		req.Header.Set(c.name, strings.ReplaceAll(c.value, tokenFormat, token))
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// fetch gets the response body for a token, only configured status codes are valid
+func (c *tokenClient) fetch(wrapper Wrapper) (string, error) {
+	req, err := c.request(wrapper.Token)
+	if err != nil {
+		return "", err
+	}
+	return readResponse(wrapper, c.client, req, c.cfg.Status)
+}
+
+// readResponse performs a request, only the given status codes are valid
+func readResponse(wrapper Wrapper, client *http.Client, req *http.Request, statuses []int) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		// the url may contain the token
+		if urlErr, ok := err.(*url.Error); ok {
+			return "", urlErr.Err
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxTokenBody))
+	if err != nil {
+		return "", err
+	}
+	valid := false
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		err := fmt.Errorf("token validation status: %d", resp.StatusCode)
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return "", rejectToken(err)
+		}
+		return "", err
+	}
+	o := strings.TrimSpace(string(b))
+	if len(o) > 0 {
+		wrapper.Debugging("response")
+		wrapper.Debugging(o)
+	}
+	return o, nil
+}
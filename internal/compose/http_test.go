@@ -0,0 +1,145 @@
+package compose
+
+import (
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func tokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("access_token")
+	if token == "" {
+		token = r.Header.Get("PRIVATE-TOKEN")
+	}
+	if token == "" {
+		token = filepath.Base(r.URL.Path)
+	}
+	switch token {
+	case "valid":
+		w.Write([]byte(`{"username": "user.name"}`))
+	case "created":
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"username": "user.name"}`))
+	default:
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+}
+
+func TestTokenClient(t *testing.T) {
+	if _, err := newTokenClient(core.TokenHTTP{URL: "http://localhost"}); err == nil {
+		t.Error("no token placement")
+	}
+	if _, err := newTokenClient(core.TokenHTTP{URL: "http://localhost", Header: "%s"}); err == nil {
+		t.Error("invalid header")
+	}
+	if _, err := newTokenClient(core.TokenHTTP{URL: "http://localhost", Query: "token", CA: "missing"}); err == nil {
+		t.Error("missing CA")
+	}
+	server := httptest.NewServer(http.HandlerFunc(tokenHandler))
+	defer server.Close()
+	store := Wrapper{ComposeFlags: core.ComposeFlags{Token: "valid"}}
+	for _, cfg := range []core.TokenHTTP{
+		{URL: server.URL + "/user", Query: "access_token"},
+		{URL: server.URL + "/user", Header: "PRIVATE-TOKEN: %s"},
+		{URL: server.URL + "/user/%s"},
+	} {
+		c, err := newTokenClient(cfg)
+		if err != nil {
+			t.Errorf("unable to create client: %v", err)
+			continue
+		}
+		store.Token = "valid"
+		if out, err := c.fetch(store); err != nil || out != `{"username": "user.name"}` {
+			t.Errorf("invalid response: %s %v", out, err)
+		}
+		store.Token = "invalid"
+		if _, err := c.fetch(store); err == nil || !isRejected(err) {
+			t.Error("should be unauthorized")
+		}
+		store.Token = "created"
+		if _, err := c.fetch(store); err == nil || isRejected(err) {
+			t.Error("status not allowed")
+		}
+	}
+	c, err := newTokenClient(core.TokenHTTP{URL: server.URL, Query: "access_token", Status: []int{200, 201}})
+	if err != nil {
+		t.Errorf("unable to create client: %v", err)
+		return
+	}
+	if _, err := c.fetch(store); err != nil {
+		t.Error("status allowed")
+	}
+}
+
+func TestTokenURL(t *testing.T) {
+	if u := tokenURL("https://localhost/user/%s?token=%s", "a b/c+d"); u != "https://localhost/user/a%20b%2Fc+d?token=a+b%2Fc%2Bd" {
+		t.Errorf("invalid url: %s", u)
+	}
+	c, err := newTokenClient(core.TokenHTTP{URL: "https://localhost/user/%s"})
+	if err != nil {
+		t.Errorf("unable to create client: %v", err)
+		return
+	}
+	req, err := c.request("a b")
+	if err != nil || req.URL.Path != "/user/a b" || req.URL.EscapedPath() != "/user/a%20b" {
+		t.Errorf("invalid path token: %v", err)
+	}
+}
+
+func TestTokenClientCA(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(tokenHandler))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	store := Wrapper{ComposeFlags: core.ComposeFlags{Token: "valid"}}
+	c, err := newTokenClient(core.TokenHTTP{URL: server.URL, Query: "access_token"})
+	if err != nil {
+		t.Errorf("unable to create client: %v", err)
+		return
+	}
+	if _, err := c.fetch(store); err == nil {
+		t.Error("unknown CA")
+	}
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(ca, b, perms); err != nil {
+		t.Error("unable to write CA")
+	}
+	c, err = newTokenClient(core.TokenHTTP{URL: server.URL, Query: "access_token", CA: ca})
+	if err != nil {
+		t.Errorf("unable to create client: %v", err)
+		return
+	}
+	if _, err := c.fetch(store); err != nil {
+		t.Errorf("should trust CA: %v", err)
+	}
+}
+
+func TestBackendHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(tokenHandler))
+	defer server.Close()
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	b, err := Open(core.ComposeFlags{Repo: repo, HTTP: core.TokenHTTP{URL: server.URL, Query: "access_token"}}, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	defer b.Close()
+	if err := b.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	if err := b.Validate("valid", "112233445566"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	if b.Validate("invalid", "112233445566") == nil {
+		t.Error("should not validate")
+	}
+}
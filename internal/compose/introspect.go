@@ -0,0 +1,128 @@
+package compose
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+type (
+	// introspector validates tokens by OAuth2 token introspection (RFC 7662)
+	introspector struct {
+		cfg    core.TokenIntrospect
+		client *http.Client
+	}
+
+	introspection struct {
+		Active   bool            `json:"active"`
+		Expires  *float64        `json:"exp"`
+		Audience json.RawMessage `json:"aud"`
+	}
+)
+
+func newIntrospector(cfg core.TokenIntrospect) (*introspector, error) {
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, err
+	}
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("no introspection client id")
+	}
+	if len(cfg.Claims) == 0 {
+		cfg.Claims = []string{"username", "sub"}
+	}
+	client, err := newHTTPClient(cfg.CA, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &introspector{cfg: cfg, client: client}, nil
+}
+
+// newTokenSource gets the configured token source (none when the payload command is used)
+func newTokenSource(flags core.ComposeFlags) (tokenSource, error) {
+	switch {
+	case flags.HTTP.URL != "" && flags.Introspect.URL != "":
+		return nil, fmt.Errorf("only one of http or introspection may be configured")
+	case flags.HTTP.URL != "":
+		return newTokenClient(flags.HTTP)
+	case flags.Introspect.URL != "":
+		return newIntrospector(flags.Introspect)
+	}
+	return nil, nil
+}
+
+// layouts are the claims (in order) that may hold the user
+func (i *introspector) layouts(search []string) [][]string {
+	var result [][]string
+	for _, claim := range i.cfg.Claims {
+		result = append(result, []string{claim})
+	}
+	return result
+}
+
+func (i *introspector) fetch(wrapper Wrapper) (string, error) {
+	form := url.Values{}
+	form.Set("token", wrapper.Token)
+	form.Set("token_type_hint", "access_token")
+	req, err := http.NewRequest(http.MethodPost, i.cfg.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(i.cfg.ClientID), url.QueryEscape(i.cfg.ClientSecret))
+	body, err := readResponse(wrapper, i.client, req, []int{http.StatusOK})
+	if err != nil {
+		return "", err
+	}
+	if err := i.check([]byte(body), time.Now()); err != nil {
+		return "", err
+	}
+	return body, nil
+}
+
+func (i *introspector) expires(body string) int64 {
+	result := introspection{}
+	if err := json.Unmarshal([]byte(body), &result); err != nil || result.Expires == nil {
+		return 0
+	}
+	return int64(*result.Expires)
+}
+
+// check confirms a token is active, not expired, and for an allowed audience
+func (i *introspector) check(body []byte, now time.Time) error {
+	result := introspection{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+	if !result.Active {
+		return rejectToken(fmt.Errorf("token is not active"))
+	}
+	if result.Expires != nil && now.Unix() >= int64(*result.Expires) {
+		return rejectToken(fmt.Errorf("token is expired"))
+	}
+	if len(i.cfg.Audience) == 0 {
+		return nil
+	}
+	var audiences []string
+	if len(result.Audience) > 0 {
+		var single string
+		if err := json.Unmarshal(result.Audience, &single); err == nil {
+			audiences = []string{single}
+		} else if err := json.Unmarshal(result.Audience, &audiences); err != nil {
+			return err
+		}
+	}
+	for _, aud := range audiences {
+		for _, allowed := range i.cfg.Audience {
+			if aud == allowed {
+				return nil
+			}
+		}
+	}
+	return rejectToken(fmt.Errorf("token audience not allowed"))
+}
@@ -0,0 +1,154 @@
+package compose
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+// laterCalls counts introspections of the "later" token (inactive after the first)
+var laterCalls int32
+
+func introspectHandler(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != "dotonex" || secret != "secret" || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	switch r.FormValue("token") {
+	case "valid":
+		fmt.Fprintf(w, `{"active": true, "exp": %d, "aud": "dotonex", "sub": "1234", "username": "user.name"}`, exp)
+	case "subject":
+		fmt.Fprintf(w, `{"active": true, "aud": ["other", "dotonex"], "sub": "user.name", "username": "display"}`)
+	case "expired":
+		fmt.Fprintf(w, `{"active": true, "exp": %d, "aud": "dotonex", "username": "user.name"}`, time.Now().Add(-time.Hour).Unix())
+	case "audience":
+		fmt.Fprintf(w, `{"active": true, "aud": "other", "username": "user.name"}`)
+	case "later":
+		if atomic.AddInt32(&laterCalls, 1) == 1 {
+			fmt.Fprintf(w, `{"active": true, "exp": %d, "aud": "dotonex", "username": "user.name"}`, exp)
+			return
+		}
+		w.Write([]byte(`{"active": false}`))
+	default:
+		w.Write([]byte(`{"active": false}`))
+	}
+}
+
+func TestIntrospectCheck(t *testing.T) {
+	i, err := newIntrospector(core.TokenIntrospect{URL: "http://localhost", ClientID: "dotonex", Audience: []string{"dotonex"}})
+	if err != nil {
+		t.Errorf("unable to create: %v", err)
+		return
+	}
+	now := time.Unix(1000, 0)
+	for body, valid := range map[string]bool{
+		`{"active": true, "aud": "dotonex"}`:              true,
+		`{"active": true, "aud": ["a", "dotonex"]}`:       true,
+		`{"active": true, "aud": "dotonex", "exp": 1001}`: true,
+		`{"active": true, "aud": "dotonex", "exp": 1000}`: false,
+		`{"active": true, "aud": "a"}`:                    false,
+		`{"active": true}`:                                false,
+		`{"active": false, "aud": "dotonex"}`:             false,
+		`{"aud": "dotonex"}`:                              false,
+		`{"active": true, "aud": {"invalid": "dotonex"}}`: false,
+		`not json`: false,
+	} {
+		if err := i.check([]byte(body), now); (err == nil) != valid {
+			t.Errorf("invalid check: %s (%v)", body, err)
+		}
+	}
+	if err := i.check([]byte(`{"active": false}`), now); !isRejected(err) {
+		t.Error("inactive tokens are rejected")
+	}
+	if err := i.check([]byte(`not json`), now); isRejected(err) {
+		t.Error("invalid responses are not rejections")
+	}
+	i.cfg.Audience = nil
+	if err := i.check([]byte(`{"active": true, "aud": "a"}`), now); err != nil {
+		t.Error("any audience")
+	}
+	if _, err := newIntrospector(core.TokenIntrospect{URL: "http://localhost"}); err == nil {
+		t.Error("no client id")
+	}
+	if _, err := newTokenSource(core.ComposeFlags{HTTP: core.TokenHTTP{URL: "http://localhost", Query: "token"}, Introspect: core.TokenIntrospect{URL: "http://localhost", ClientID: "id"}}); err == nil {
+		t.Error("only one source")
+	}
+	if s, err := newTokenSource(core.ComposeFlags{}); s != nil || err != nil {
+		t.Error("no source")
+	}
+}
+
+func TestBackendIntrospect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(introspectHandler))
+	defer server.Close()
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	cfg := core.TokenIntrospect{URL: server.URL, ClientID: "dotonex", ClientSecret: "secret", Audience: []string{"dotonex"}}
+	b, err := Open(core.ComposeFlags{Repo: repo, Introspect: cfg}, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	defer b.Close()
+	if err := b.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	for token, valid := range map[string]bool{
+		"valid":    true,
+		"subject":  true,
+		"expired":  false,
+		"audience": false,
+		"inactive": false,
+	} {
+		if err := b.Validate(token, "112233445566"); (err == nil) != valid {
+			t.Errorf("invalid validation: %s (%v)", token, err)
+		}
+	}
+	expires := func(token string) (string, bool) {
+		hash, _ := core.MD4(token)
+		val, ok, _ := b.store.Get(expiresKey(Wrapper{}.NewKey(hash)))
+		return val, ok
+	}
+	if val, ok := expires("valid"); !ok || val <= strconv.FormatInt(time.Now().Unix(), 10) {
+		t.Errorf("exp not stored: %s", val)
+	}
+	if _, ok := expires("subject"); ok {
+		t.Error("no exp")
+	}
+	if err := b.Validate("later", "112233445566"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	if err := b.Validate("later", "112233445566"); err != nil {
+		t.Errorf("stored token: %v", err)
+	}
+	hash, _ := core.MD4("later")
+	if b.store.Save(expiresKey(Wrapper{}.NewKey(hash)), "1") != nil {
+		t.Error("unable to expire")
+	}
+	if b.Validate("later", "112233445566") == nil {
+		t.Error("token is past exp")
+	}
+	if _, ok, _ := b.store.Get(Wrapper{}.NewKey(hash)); ok {
+		t.Error("token should be revoked")
+	}
+	if _, ok := expires("later"); ok {
+		t.Error("exp should be removed")
+	}
+	cfg.ClientSecret = "wrong"
+	i, err := newIntrospector(cfg)
+	if err != nil {
+		t.Errorf("unable to create: %v", err)
+		return
+	}
+	if _, err := i.fetch(Wrapper{ComposeFlags: core.ComposeFlags{Token: "valid"}}); err == nil {
+		t.Error("invalid client")
+	}
+}
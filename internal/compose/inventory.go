@@ -0,0 +1,179 @@
+package compose
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const (
+	// InventoryTable writes an inventory as a table
+	InventoryTable = "table"
+	// InventoryJSON writes an inventory as JSON
+	InventoryJSON = "json"
+)
+
+type (
+	// Inventory is the users and MAB devices of a repository (with the stored state of users)
+	Inventory struct {
+		Users []UserInventory `json:"users"`
+		MAB   []MABInventory  `json:"mab"`
+	}
+
+	// UserInventory is a user's token state, VLAN memberships, and MACs
+	UserInventory struct {
+		Name      string   `json:"name"`
+		Token     bool     `json:"token"`
+		Validated string   `json:"validated,omitempty"`
+		TLS       bool     `json:"tls"`
+		VLANs     []string `json:"vlans"`
+		MACs      []string `json:"macs"`
+		// Missing users have no stored token (or TLS logins) and are not in eap_users
+		Missing bool `json:"missing"`
+	}
+
+	// MABInventory is the MAB devices of a VLAN
+	MABInventory struct {
+		VLAN string   `json:"vlan"`
+		MACs []string `json:"macs"`
+	}
+)
+
+func macs(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, entry := range entries {
+		if mac, ok := core.CleanMAC(entry.Name()); ok {
+			result = append(result, mac)
+		}
+	}
+	return result, nil
+}
+
+func inventory(wrapper Wrapper) (Inventory, error) {
+	inv := Inventory{Users: []UserInventory{}, MAB: []MABInventory{}}
+	def, err := getVLANs(wrapper)
+	if err != nil {
+		return inv, err
+	}
+	dirs, err := os.ReadDir(wrapper.Repo)
+	if err != nil {
+		return inv, err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		name := dir.Name()
+		path := filepath.Join(wrapper.Repo, name)
+		found, err := macs(path)
+		if err != nil {
+			return inv, err
+		}
+		if _, ok := def.IsVLAN(name); ok {
+			inv.MAB = append(inv.MAB, MABInventory{VLAN: name, MACs: found})
+			continue
+		}
+		cfg := filepath.Join(path, vlanConfig)
+		if !core.PathExists(cfg) {
+			continue
+		}
+		user := UserInventory{Name: name, MACs: found, VLANs: []string{}}
+		b, err := os.ReadFile(cfg)
+		if err != nil {
+			return inv, err
+		}
+		d := Definition{}
+		if err := yaml.Unmarshal(b, &d); err != nil {
+			core.WriteError(fmt.Sprintf("unable to read user yaml: %s", name), err)
+		}
+		for _, m := range d.Membership {
+			user.VLANs = append(user.VLANs, m.VLAN)
+		}
+		token, ok, err := wrapper.Get(wrapper.NewKey(name))
+		if err != nil {
+			return inv, err
+		}
+		user.Token = ok && token != ""
+		user.TLS = d.UsesTLS(def)
+		user.Missing = !user.Token && !user.TLS
+		if user.Token {
+			hash, err := core.MD4(token)
+			if err != nil {
+				return inv, err
+			}
+			val, ok, err := wrapper.Get(validatedKey(wrapper.NewKey(hash)))
+			if err != nil {
+				return inv, err
+			}
+			if ok {
+				if unix, err := strconv.ParseInt(val, 10, 64); err == nil {
+					user.Validated = time.Unix(unix, 0).Format(time.RFC3339)
+				}
+			}
+		}
+		inv.Users = append(inv.Users, user)
+	}
+	return inv, nil
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+func orNone(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ",")
+}
+
+// Write outputs the inventory in a format (table or json)
+func (i Inventory) Write(w io.Writer, format string) error {
+	switch format {
+	case "", InventoryTable:
+	case InventoryJSON:
+		b, err := json.MarshalIndent(i, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	default:
+		return fmt.Errorf("unknown inventory format: %s", format)
+	}
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "USER\tTOKEN\tTLS\tVALIDATED\tVLANS\tMACS\tNOTE")
+	for _, u := range i.Users {
+		note := ""
+		if u.Missing {
+			note = "not in eap_users (no token)"
+		}
+		validated := u.Validated
+		if validated == "" {
+			validated = "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", u.Name, yesNo(u.Token), yesNo(u.TLS), validated, orNone(u.VLANs), orNone(u.MACs), note)
+	}
+	fmt.Fprintln(table)
+	fmt.Fprintln(table, "MAB\tMACS")
+	for _, m := range i.MAB {
+		fmt.Fprintf(table, "%s\t%s\n", m.VLAN, orNone(m.MACs))
+	}
+	return table.Flush()
+}
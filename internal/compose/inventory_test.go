@@ -0,0 +1,77 @@
+package compose
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func TestInventory(t *testing.T) {
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	other := filepath.Join(repo, "other.name")
+	if err := os.Mkdir(other, 0700); err != nil {
+		t.Error("unable to create user")
+	}
+	if err := os.WriteFile(filepath.Join(other, vlanConfig), []byte("membership:\n    - vlan: xyz\n"), perms); err != nil {
+		t.Error("unable to write user")
+	}
+	b, err := Open(core.ComposeFlags{Repo: repo, Command: []string{"echo", `{"username": "user.name"}`}}, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	defer b.Close()
+	if err := b.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	if err := b.Validate("token", "112233445566"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	inv, err := b.Inventory()
+	if err != nil {
+		t.Errorf("no inventory: %v", err)
+		return
+	}
+	if len(inv.Users) != 2 || len(inv.MAB) != 1 {
+		t.Errorf("invalid inventory: %v", inv)
+		return
+	}
+	missing, user := inv.Users[0], inv.Users[1]
+	if missing.Name != "other.name" || missing.Token || !missing.Missing || missing.Validated != "" || len(missing.MACs) != 0 {
+		t.Errorf("invalid missing user: %v", missing)
+	}
+	if user.Name != "user.name" || !user.Token || user.Missing || user.Validated == "" {
+		t.Errorf("invalid user: %v", user)
+	}
+	if len(user.VLANs) != 1 || user.VLANs[0] != "abc" || len(user.MACs) != 1 || user.MACs[0] != "112233445566" {
+		t.Errorf("invalid user devices: %v", user)
+	}
+	if inv.MAB[0].VLAN != "xyz" || len(inv.MAB[0].MACs) != 1 {
+		t.Errorf("invalid mab: %v", inv.MAB)
+	}
+	var buffer bytes.Buffer
+	if err := inv.Write(&buffer, InventoryJSON); err != nil {
+		t.Error("unable to write json")
+	}
+	read := Inventory{}
+	if err := json.Unmarshal(buffer.Bytes(), &read); err != nil || len(read.Users) != 2 {
+		t.Error("invalid json")
+	}
+	buffer.Reset()
+	if err := inv.Write(&buffer, ""); err != nil {
+		t.Error("unable to write table")
+	}
+	text := buffer.String()
+	if !strings.Contains(text, "not in eap_users") || !strings.Contains(text, "aabbccddeeff") {
+		t.Errorf("invalid table:\n%s", text)
+	}
+	if inv.Write(&buffer, "xml") == nil {
+		t.Error("unknown format")
+	}
+}
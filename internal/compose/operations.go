@@ -0,0 +1,736 @@
+package compose
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const (
+	bin        = "bin"
+	serverHash = "server"
+	perms      = 0600
+	vlanConfig = "vlans.cfg"
+	lockFile   = "dotonex.lock"
+	storeFile  = "dotonex.db"
+	generation = "generation"
+)
+
+type (
+	// rejectedToken is a token the source answered for and did not accept, unlike a failure
+	// to reach the source (expired tokens are only revoked when rejected)
+	rejectedToken struct {
+		err error
+	}
+)
+
+func (r rejectedToken) Error() string {
+	return r.err.Error()
+}
+
+func (r rejectedToken) Unwrap() error {
+	return r.err
+}
+
+func rejectToken(err error) error {
+	return rejectedToken{err: err}
+}
+
+func isRejected(err error) bool {
+	var rejected rejectedToken
+	return errors.As(err, &rejected)
+}
+
+func piped(wrapper Wrapper, args []string) (string, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	ctx := context.Background()
+	if wrapper.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wrapper.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	o := strings.TrimSpace(stdout.String())
+	if len(o) > 0 {
+		wrapper.Debugging("stdout")
+		wrapper.Debugging(o)
+	}
+	e := stderr.String()
+	if len(e) > 0 {
+		wrapper.Debugging("stderr")
+		wrapper.Debugging(e)
+		return "", fmt.Errorf("command errored")
+	}
+	return o, nil
+}
+
+// knownUser checks the MAC and gets the user of a previously validated token
+// (and whether the token must be validated again)
+func knownUser(wrapper Wrapper) (string, string, bool, error) {
+	wrapper.Debugging("validating inputs")
+	if err := checkMAC(wrapper, false); err != nil {
+		return "", "", false, err
+	}
+	hash, err := core.MD4(wrapper.Token)
+	if err != nil {
+		return "", "", false, err
+	}
+	tokenKey := wrapper.NewKey(hash)
+	user, ok, err := wrapper.Get(tokenKey)
+	if err != nil {
+		return "", "", false, err
+	}
+	if !ok {
+		return tokenKey, user, false, nil
+	}
+	wrapper.Debugging("token is known")
+	exp, ok, err := wrapper.Get(expiresKey(tokenKey))
+	if err != nil {
+		return "", "", false, err
+	}
+	if ok {
+		expires, err := strconv.ParseInt(exp, 10, 64)
+		if err != nil || time.Now().Unix() >= expires {
+			wrapper.Debugging("token exp passed")
+			return tokenKey, user, true, nil
+		}
+	}
+	if wrapper.Revalidate <= 0 {
+		return tokenKey, user, false, nil
+	}
+	val, ok, err := wrapper.Get(validatedKey(tokenKey))
+	if err != nil {
+		return "", "", false, err
+	}
+	if !ok {
+		// tokens stored before validation times were recorded are stamped by the store upgrade
+		return tokenKey, user, false, nil
+	}
+	validated, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || time.Since(time.Unix(validated, 0)) >= time.Duration(wrapper.Revalidate)*time.Minute {
+		wrapper.Debugging("token expired")
+		return tokenKey, user, true, nil
+	}
+	return tokenKey, user, false, nil
+}
+
+// validatedKey is the key of the time a token was last validated
+func validatedKey(tokenKey string) string {
+	return tokenKey + "=>validated"
+}
+
+// validated records when a token was validated (revalidate decides when it expires)
+// and when the token source says the token expires
+func validated(wrapper Wrapper, tokenKey string, expires int64) error {
+	if expires > 0 {
+		if err := wrapper.Save(expiresKey(tokenKey), strconv.FormatInt(expires, 10)); err != nil {
+			return err
+		}
+	} else {
+		if err := wrapper.Delete(expiresKey(tokenKey)); err != nil {
+			return err
+		}
+	}
+	return wrapper.Save(validatedKey(tokenKey), strconv.FormatInt(time.Now().Unix(), 10))
+}
+
+// expiresKey is the key of the time (from the token source) a token expires
+func expiresKey(tokenKey string) string {
+	return tokenKey + "=>expires"
+}
+
+// deleteToken removes a token and the times kept for it
+func deleteToken(wrapper Wrapper, tokenKey string) error {
+	for _, key := range []string{tokenKey, validatedKey(tokenKey), expiresKey(tokenKey)} {
+		if err := wrapper.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tokenUser gets the user of a token (by a token source or the payload command)
+// and when the token expires (0 when the source does not say)
+func tokenUser(wrapper Wrapper) (string, int64, error) {
+	var output string
+	var expires int64
+	layouts := [][]string{wrapper.Search}
+	if wrapper.source != nil {
+		body, err := wrapper.source.fetch(wrapper)
+		if err != nil {
+			return "", 0, err
+		}
+		output = body
+		layouts = wrapper.source.layouts(wrapper.Search)
+		expires = wrapper.source.expires(body)
+	} else {
+		if len(wrapper.Command) == 0 {
+			return "", 0, fmt.Errorf("no payload command")
+		}
+		command := []string{}
+		for _, c := range wrapper.Command {
+			text := c
+			if strings.Contains(c, "%s") {
+				text = fmt.Sprintf(c, wrapper.Token)
+			}
+			command = append(command, text)
+		}
+		out, err := piped(wrapper, command)
+		if err != nil {
+			return "", 0, err
+		}
+		output = out
+	}
+	user, err := findUser(layouts, []byte(output), func(possibleUser string) bool {
+		return core.PathExists(filepath.Join(wrapper.Repo, possibleUser))
+	})
+	if err != nil {
+		return "", 0, rejectToken(err)
+	}
+	if err := CheckRequired(wrapper.Require, []byte(output)); err != nil {
+		return "", 0, rejectToken(err)
+	}
+	wrapper.Debugging(fmt.Sprintf("%s token changed", user))
+	return user, expires, nil
+}
+
+// saveUser stores a newly validated token for a user and rebuilds
+func saveUser(wrapper Wrapper, tokenKey, user string, expires int64) error {
+	if err := wrapper.Save(tokenKey, user); err != nil {
+		return err
+	}
+	if err := validated(wrapper, tokenKey, expires); err != nil {
+		return err
+	}
+	wrapper.Debugging("token validated")
+	if err := foundUser(wrapper, user); err != nil {
+		return err
+	}
+	wrapper.Debugging("user is new")
+	userKey := wrapper.NewKey(user)
+	if err := wrapper.Save(userKey, wrapper.Token); err != nil {
+		return err
+	}
+	if err := touch(wrapper); err != nil {
+		return err
+	}
+	return build(wrapper, true)
+}
+
+// revokeToken removes a token that is no longer valid, the user is removed
+// when it is their current token
+func revokeToken(wrapper Wrapper, tokenKey, user string) error {
+	wrapper.Debugging("token revoked")
+	if err := deleteToken(wrapper, tokenKey); err != nil {
+		return err
+	}
+	current, ok, err := wrapper.Get(wrapper.NewKey(user))
+	if err != nil || !ok {
+		return err
+	}
+	hash, err := core.MD4(current)
+	if err != nil {
+		return err
+	}
+	if wrapper.NewKey(hash) != tokenKey {
+		return nil
+	}
+	return removeUser(wrapper, user)
+}
+
+// revoke removes every token of a user and the user
+func revoke(wrapper Wrapper) error {
+	user := wrapper.User
+	prefix := wrapper.NewKey("")
+	var tokenKeys []string
+	if err := wrapper.store.Each(func(key, value string) error {
+		if value != user || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		if _, err := hex.DecodeString(strings.TrimPrefix(key, prefix)); err == nil {
+			tokenKeys = append(tokenKeys, key)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	_, ok, err := wrapper.Get(wrapper.NewKey(user))
+	if err != nil {
+		return err
+	}
+	if !ok && len(tokenKeys) == 0 {
+		return fmt.Errorf("unknown user: %s", user)
+	}
+	for _, key := range tokenKeys {
+		if err := deleteToken(wrapper, key); err != nil {
+			return err
+		}
+	}
+	wrapper.Debugging(fmt.Sprintf("%s tokens revoked: %d", user, len(tokenKeys)))
+	return removeUser(wrapper, user)
+}
+
+// removeUser removes the user's login and rebuilds
+func removeUser(wrapper Wrapper, user string) error {
+	wrapper.Debugging(fmt.Sprintf("%s removed", user))
+	if err := wrapper.Delete(wrapper.NewKey(user)); err != nil {
+		return err
+	}
+	if err := touch(wrapper); err != nil {
+		return err
+	}
+	return build(wrapper, true)
+}
+
+// touch marks that the store changed, hosts sharing the store rebuild on their next build
+func touch(wrapper Wrapper) error {
+	key := wrapper.NewKey(generation)
+	val, ok, err := wrapper.Get(key)
+	if err != nil {
+		return err
+	}
+	current := 0
+	if ok {
+		current, err = strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+	}
+	return wrapper.Save(key, strconv.Itoa(current+1))
+}
+
+func foundUser(wrapper Wrapper, user string) error {
+	if user == "" {
+		return fmt.Errorf("empty user found")
+	}
+	wrapper.Debugging(fmt.Sprintf("user found: %s", user))
+	return nil
+}
+
+// checkUser confirms the user has the MAC and a VLAN configuration
+func checkUser(wrapper Wrapper, user string) error {
+	// the mac has been checked as "clean" by being generically checked earlier
+	mac, _ := core.CleanMAC(wrapper.MAC)
+	userDir := filepath.Join(wrapper.Repo, user)
+	for _, file := range []string{mac, vlanConfig} {
+		if !core.PathExists(filepath.Join(userDir, file)) {
+			return fmt.Errorf("%s file not found", file)
+		}
+	}
+
+	wrapper.Debugging("validated")
+	return nil
+}
+
+func fetch(wrapper Wrapper) error {
+	for _, cmd := range []string{"fetch", "pull"} {
+		command := exec.Command("git", "-C", wrapper.Repo, cmd)
+		command.Stdout = os.Stdout
+		command.Stderr = os.Stderr
+		if err := command.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func server(wrapper Wrapper) error {
+	serverKey := wrapper.NewKey(serverHash)
+	val, ok, err := wrapper.Get(serverKey)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if val == wrapper.Hash {
+			return nil
+		}
+	}
+	wrapper.Debugging("hash update")
+	if err := wrapper.Save(serverKey, wrapper.Hash); err != nil {
+		return err
+	}
+	if err := touch(wrapper); err != nil {
+		return err
+	}
+	return build(wrapper, true)
+}
+
+// checkTLS confirms a user has TLS (certificate) logins and the MAC
+func checkTLS(wrapper Wrapper) error {
+	mac, ok := core.CleanMAC(wrapper.MAC)
+	if !ok {
+		return fmt.Errorf("invalid MAC")
+	}
+	path := filepath.Join(wrapper.Repo, wrapper.User)
+	cfg := filepath.Join(path, vlanConfig)
+	if wrapper.User == "" || strings.HasPrefix(wrapper.User, ".") || filepath.Base(wrapper.User) != wrapper.User || !core.PathExists(cfg) {
+		return fmt.Errorf("unknown user: %s", wrapper.User)
+	}
+	def, err := getVLANs(wrapper)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(cfg)
+	if err != nil {
+		return err
+	}
+	d := Definition{}
+	if err := yaml.Unmarshal(b, &d); err != nil {
+		return err
+	}
+	if !d.UsesTLS(def) {
+		return fmt.Errorf("%s does not use tls", wrapper.User)
+	}
+	if !core.PathExists(filepath.Join(path, mac)) {
+		return fmt.Errorf("unable to find mac: %s (tls)", wrapper.MAC)
+	}
+	wrapper.Debugging("validated")
+	return nil
+}
+
+func checkMAC(wrapper Wrapper, mab bool) error {
+	mac, ok := core.CleanMAC(wrapper.MAC)
+	if !ok {
+		return fmt.Errorf("invalid MAC")
+	}
+	dirs, err := os.ReadDir(wrapper.Repo)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		sub := filepath.Join(wrapper.Repo, dir.Name())
+		if core.PathExists(filepath.Join(sub, mac)) {
+			if mab {
+				if core.PathExists(filepath.Join(sub, vlanConfig)) {
+					wrapper.Debugging(fmt.Sprintf("%s MAC is user, not mab", mac))
+					continue
+				}
+			}
+			return nil
+		}
+	}
+	mode := "user"
+	if mab {
+		mode = "mab"
+	}
+	return fmt.Errorf("unable to find mac: %s (%s)", wrapper.MAC, mode)
+}
+
+func getHostapd(wrapper Wrapper, def Definition) ([]Hostapd, error) {
+	hashKey := wrapper.NewKey(serverHash)
+	hash, ok, err := wrapper.Get(hashKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no server hash found")
+	}
+	dirs, err := os.ReadDir(wrapper.Repo)
+	if err != nil {
+		return nil, err
+	}
+	if len(hash) == 0 {
+		return nil, fmt.Errorf("empty hash")
+	}
+	var directory *directoryGroups
+	if wrapper.LDAP.URL != "" {
+		conn, err := dialGroups(wrapper.LDAP)
+		if err != nil {
+			return nil, err
+		}
+		directory, err = conn.all()
+		conn.close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	vlanAttributes := make(map[string][]string)
+	for _, v := range def.VLANs {
+		lines, err := RenderAttributes(v.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		vlanAttributes[v.Name] = lines
+	}
+	var result []Hostapd
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		name := dir.Name()
+		path := filepath.Join(wrapper.Repo, name)
+		if vlan, ok := def.FindVLAN(name); ok {
+			wrapper.Debugging(fmt.Sprintf("%s (MAB)", name))
+			sub, err := os.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, mac := range sub {
+				cleaned, ok := core.CleanMAC(mac.Name())
+				if !ok {
+					continue
+				}
+				wrapper.Debugging(fmt.Sprintf(" -> %s", cleaned))
+				result = append(result, NewHostapd(cleaned, cleaned, vlan.ID).With(vlanAttributes[vlan.Name]))
+			}
+			continue
+		}
+		possible := filepath.Join(path, vlanConfig)
+		if !core.PathExists(possible) {
+			continue
+		}
+		secretKey := wrapper.NewKey(name)
+		loginName, hasToken, err := wrapper.Get(secretKey)
+		if err != nil {
+			return nil, err
+		}
+		if hasToken {
+			wrapper.Debugging(fmt.Sprintf("%s (USER)", name))
+			if len(loginName) == 0 {
+				core.WriteWarn("empty login name")
+				hasToken = false
+			}
+		}
+		var userPassword string
+		if hasToken {
+			loginName = core.NewUserLogin(name, loginName)
+			userPassword, hasToken, err = userHash(wrapper, name, hash)
+			if err != nil {
+				return nil, err
+			}
+			if !hasToken {
+				core.WriteWarn(fmt.Sprintf("no password for %s", name))
+			}
+		}
+		b, err := os.ReadFile(possible)
+		if err != nil {
+			return nil, err
+		}
+		d := Definition{}
+		if err := yaml.Unmarshal(b, &d); err != nil {
+			core.WriteError("unable to read user yaml", err)
+			continue
+		}
+		// users without a token may still login by certificate
+		if !hasToken && !d.TLS && !def.HasTLS() {
+			continue
+		}
+		if directory != nil {
+			groups, err := directory.groups(name)
+			if err != nil {
+				core.WriteWarn(fmt.Sprintf("using the repository membership of %s", name), err.Error())
+			} else {
+				members := def.GroupMembership(groups)
+				wrapper.Debugging(fmt.Sprintf("%s ldap groups: %v -> %v", name, groups, members))
+				if wrapper.LDAP.Override {
+					d.Membership = members
+				} else {
+					d.MergeMembership(members)
+				}
+			}
+		}
+		if err := d.ValidateMembership(); err != nil {
+			core.WriteError("invalid memberships found", err)
+			continue
+		}
+		userAttributes, err := RenderAttributes(d.Attributes)
+		if err != nil {
+			core.WriteError(fmt.Sprintf("invalid attributes for %s", name), err)
+			continue
+		}
+		method := d.Method
+		if method == "" {
+			method = wrapper.Method
+		}
+		if !ValidMethod(method) {
+			core.WriteWarn(fmt.Sprintf("invalid method %s for %s", method, name))
+			hasToken = false
+		}
+		credential := userPassword
+		if hasToken && method == core.MethodTTLSPAP {
+			if wrapper.Passwords != core.PasswordsDerived {
+				core.WriteWarn(fmt.Sprintf("%s requires derived passwords (%s)", method, name))
+				hasToken = false
+			} else {
+				derived, err := derivePassword(wrapper.Key, name)
+				if err != nil {
+					return nil, err
+				}
+				credential = derived
+			}
+		}
+		userMACs, err := macs(path)
+		if err != nil {
+			return nil, err
+		}
+		first := true
+		firstTLS := true
+		for _, member := range d.Membership {
+			vlan, ok := def.FindVLAN(member.VLAN)
+			if !ok {
+				core.WriteWarn(fmt.Sprintf("invalid VLAN %s", member.VLAN))
+				continue
+			}
+			attrs := append(append([]string{}, vlanAttributes[vlan.Name]...), userAttributes...)
+			if d.TLS || vlan.TLS {
+				if firstTLS {
+					wrapper.Debugging(fmt.Sprintf("%s (TLS)", name))
+					result = append(result, NewTLSHostapd(name, vlan.ID).With(attrs).Using(userMACs))
+					firstTLS = false
+				}
+				result = append(result, NewTLSHostapd(core.NewUserVLANLogin(name, member.VLAN), vlan.ID).With(attrs).Using(userMACs))
+				continue
+			}
+			if !hasToken {
+				continue
+			}
+			if first {
+				result = append(result, NewMethodHostapd(loginName, method, credential, vlan.ID).With(attrs).Using(userMACs))
+				first = false
+			}
+			result = append(result, NewMethodHostapd(core.NewUserVLANLogin(loginName, member.VLAN), method, credential, vlan.ID).With(attrs).Using(userMACs))
+		}
+	}
+	return result, nil
+}
+
+func getVLANs(wrapper Wrapper) (Definition, error) {
+	cfg := filepath.Join(wrapper.Repo, vlanConfig)
+	d := Definition{}
+	if !core.PathExists(cfg) {
+		return d, fmt.Errorf("no root vlan config found")
+	}
+	b, err := os.ReadFile(cfg)
+	if err != nil {
+		return d, err
+	}
+	if err := yaml.Unmarshal(b, &d); err != nil {
+		return d, err
+	}
+
+	if err := d.ValidateVLANs(); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+func configure(wrapper Wrapper) error {
+	wrapper.Debugging("configuring")
+	vlans, err := getVLANs(wrapper)
+	if err != nil {
+		return err
+	}
+	hostapd, err := getHostapd(wrapper, vlans)
+	if err != nil {
+		return err
+	}
+	var eapUsers []string
+	for _, h := range hostapd {
+		eapUsers = append(eapUsers, h.String())
+	}
+	if len(eapUsers) == 0 {
+		return fmt.Errorf("no hostapd configurations found")
+	}
+	// user MACs are not in eap_users, assignments change without it
+	if err := writeAssignments(wrapper, hostapd); err != nil {
+		return err
+	}
+	sort.Strings(eapUsers)
+	hostapdFile := filepath.Join(wrapper.Repo, bin, "eap_users")
+	hostapdText := strings.Join(eapUsers, "\n\n") + "\n"
+	if core.PathExists(hostapdFile) {
+		b, err := os.ReadFile(hostapdFile)
+		if err != nil {
+			return err
+		}
+		if hostapdText == string(b) {
+			wrapper.Debugging("no hostapd changes")
+			return nil
+		}
+	}
+	if err := os.WriteFile(hostapdFile, []byte(hostapdText), perms); err != nil {
+		return err
+	}
+	return resetHostapd(wrapper)
+}
+
+func resetHostapd(wrapper Wrapper) error {
+	wrapper.Debugging("hostapd reset")
+	pids, err := piped(wrapper, []string{"pidof", "hostapd"})
+	if err != nil {
+		core.WriteWarn(fmt.Sprintf("unable to get hostapd pids: %v", err))
+		return nil
+	}
+	for _, pid := range strings.Split(pids, " ") {
+		p := strings.TrimSpace(pid)
+		if len(p) == 0 {
+			continue
+		}
+		cmd := exec.Command("kill", "-HUP", p)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func build(wrapper Wrapper, force bool) error {
+	if !force {
+		last, err := piped(wrapper, []string{"git", "-C", wrapper.Repo, "log", "-n", "1", "--format=%h"})
+		if err != nil {
+			return err
+		}
+		last = strings.TrimSpace(last)
+		if len(last) == 0 {
+			return fmt.Errorf("no commit retrieved")
+		}
+		gen, _, err := wrapper.Get(wrapper.NewKey(generation))
+		if err != nil {
+			return err
+		}
+		last = fmt.Sprintf("%s/%s", last, gen)
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		// the store may be shared, the last build is per host
+		lastKey := wrapper.NewKey("last=>" + host)
+		val, ok, err := wrapper.Get(lastKey)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if val == last {
+				wrapper.Debugging("no config changes found")
+				return nil
+			}
+		}
+		if err := wrapper.Save(lastKey, last); err != nil {
+			return err
+		}
+	}
+	return configure(wrapper)
+}
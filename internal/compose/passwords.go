@@ -0,0 +1,87 @@
+package compose
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const (
+	// passwordFile holds a user's password hash (repository passwords)
+	passwordFile = "password"
+	derivedBytes = 15
+)
+
+// derivePassword derives a user's password from the server key (HMAC-SHA256 of the user), the
+// key is not the stored (or shared) server hash so the store does not reveal passwords
+func derivePassword(key, user string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("no server key for derived passwords")
+	}
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(user))
+	sum := h.Sum(nil)[:derivedBytes]
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum)), nil
+}
+
+// userHash gets the hash of a user's password for eap_users (false when the user has no password)
+func userHash(wrapper Wrapper, user, serverHash string) (string, bool, error) {
+	switch wrapper.Passwords {
+	case "", core.PasswordsShared:
+		return serverHash, true, nil
+	case core.PasswordsDerived:
+		derived, err := derivePassword(wrapper.Key, user)
+		if err != nil {
+			return "", false, err
+		}
+		hash, err := core.MD4(derived)
+		if err != nil {
+			return "", false, err
+		}
+		return hash, true, nil
+	case core.PasswordsRepository:
+		path := filepath.Join(wrapper.Repo, user, passwordFile)
+		if !core.PathExists(path) {
+			return "", false, nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, err
+		}
+		hash := strings.ToLower(strings.TrimSpace(string(b)))
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 16 {
+			return "", false, fmt.Errorf("invalid password hash for %s", user)
+		}
+		return hash, true, nil
+	}
+	return "", false, fmt.Errorf("unknown passwords: %s", wrapper.Passwords)
+}
+
+// password gets a user's (derived) password, the token must be the user's token
+func password(wrapper Wrapper) (string, error) {
+	switch wrapper.Passwords {
+	case core.PasswordsDerived:
+	case core.PasswordsRepository:
+		return "", fmt.Errorf("passwords are set in the repository")
+	default:
+		return "", fmt.Errorf("passwords are shared (the server key)")
+	}
+	if !core.PathExists(filepath.Join(wrapper.Repo, wrapper.User, vlanConfig)) {
+		return "", fmt.Errorf("unknown user: %s", wrapper.User)
+	}
+	found, _, err := tokenUser(wrapper)
+	if err != nil {
+		return "", err
+	}
+	if found != wrapper.User {
+		return "", fmt.Errorf("token is not for user: %s", wrapper.User)
+	}
+	return derivePassword(wrapper.Key, wrapper.User)
+}
@@ -0,0 +1,171 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func derived(t *testing.T, key, user string) string {
+	p, err := derivePassword(key, user)
+	if err != nil {
+		t.Errorf("unable to derive: %v", err)
+	}
+	return p
+}
+
+func TestDerivePassword(t *testing.T) {
+	p := derived(t, "key", "user.name")
+	if len(p) != 24 || p != derived(t, "key", "user.name") {
+		t.Errorf("invalid password: %s", p)
+	}
+	if p == derived(t, "key", "other.name") || p == derived(t, "other", "user.name") {
+		t.Error("passwords should differ")
+	}
+	if strings.ToLower(p) != p {
+		t.Error("password should be lowercase")
+	}
+	if _, err := derivePassword("", "user.name"); err == nil {
+		t.Error("no key")
+	}
+}
+
+func TestPasswords(t *testing.T) {
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	flags := core.ComposeFlags{Repo: repo, Command: []string{"echo", `{"username": "user.name"}`}}
+	b, err := Open(flags, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	defer b.Close()
+	if err := b.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	if err := b.Validate("token", "112233445566"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	eapUsers := func() string {
+		if err := b.Rebuild(); err != nil {
+			t.Errorf("rebuild failed: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(repo, bin, "eap_users"))
+		if err != nil {
+			t.Error("not built")
+		}
+		return string(data)
+	}
+	if !strings.Contains(eapUsers(), "hash:hash [2]") {
+		t.Error("shared password")
+	}
+	if _, err := b.Password("user.name", "token"); err == nil {
+		t.Error("shared passwords are not shown")
+	}
+	b.flags.Passwords = core.PasswordsDerived
+	if b.Rebuild() == nil {
+		t.Error("derived passwords require the key")
+	}
+	b.flags.Key = "key"
+	password, err := b.Password("user.name", "token")
+	if err != nil || password != derived(t, "key", "user.name") {
+		t.Errorf("invalid derived password: %v", err)
+	}
+	if _, err := b.Password("other.name", "token"); err == nil {
+		t.Error("unknown user")
+	}
+	b.flags.Command = []string{"echo", `{"username": "other.name"}`}
+	if _, err := b.Password("user.name", "other"); err == nil {
+		t.Error("token is not the user's")
+	}
+	b.flags.Command = []string{"false"}
+	if _, err := b.Password("user.name", "invalid"); err == nil {
+		t.Error("invalid token")
+	}
+	b.flags.Command = flags.Command
+	hash, _ := core.MD4(password)
+	if !strings.Contains(eapUsers(), "hash:"+hash+" [2]") {
+		t.Error("derived password")
+	}
+	b.flags.Passwords = core.PasswordsRepository
+	if _, err := b.Password("user.name", "token"); err == nil {
+		t.Error("repository passwords are not shown")
+	}
+	if strings.Contains(eapUsers(), "user.name:") {
+		t.Error("no repository password")
+	}
+	passwordPath := filepath.Join(repo, "user.name", passwordFile)
+	if err := os.WriteFile(passwordPath, []byte("invalid\n"), perms); err != nil {
+		t.Error("unable to write password")
+	}
+	if b.Rebuild() == nil {
+		t.Error("invalid password hash")
+	}
+	if err := os.WriteFile(passwordPath, []byte("8846F7EAEE8FB117AD06BDD830B7586C\n"), perms); err != nil {
+		t.Error("unable to write password")
+	}
+	if !strings.Contains(eapUsers(), "hash:8846f7eaee8fb117ad06bdd830b7586c [2]") {
+		t.Error("repository password")
+	}
+	if b.MAC("112233445566") == nil {
+		t.Error("user MAC is not MAB")
+	}
+	b.flags.Passwords = "other"
+	if b.Rebuild() == nil {
+		t.Error("unknown passwords")
+	}
+}
+
+func TestMethods(t *testing.T) {
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	flags := core.ComposeFlags{Repo: repo, Method: core.MethodTTLSPAP, Command: []string{"echo", `{"username": "user.name"}`}}
+	b, err := Open(flags, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	defer b.Close()
+	if err := b.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	if err := b.Validate("token", "112233445566"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	eapUsers := func() string {
+		if err := b.Rebuild(); err != nil {
+			t.Errorf("rebuild failed: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(repo, bin, "eap_users"))
+		if err != nil {
+			t.Error("not built")
+		}
+		return string(data)
+	}
+	if strings.Contains(eapUsers(), "user.name:") {
+		t.Error("pap requires derived passwords")
+	}
+	b.flags.Passwords = core.PasswordsDerived
+	b.flags.Key = "key"
+	pap := fmt.Sprintf(`"user.name:token" TTLS-PAP "%s" [2]`, derived(t, "key", "user.name"))
+	if !strings.Contains(eapUsers(), pap) {
+		t.Error("invalid pap login")
+	}
+	userConfig := filepath.Join(repo, "user.name", vlanConfig)
+	if err := os.WriteFile(userConfig, []byte("method: ttls-mschapv2\nmembership:\n    - vlan: abc\n"), perms); err != nil {
+		t.Error("unable to write user")
+	}
+	if !strings.Contains(eapUsers(), `"user.name:token" TTLS-MSCHAPV2 hash:`) {
+		t.Error("user method")
+	}
+	if err := os.WriteFile(userConfig, []byte("method: other\nmembership:\n    - vlan: abc\n"), perms); err != nil {
+		t.Error("unable to write user")
+	}
+	if strings.Contains(eapUsers(), "user.name:") {
+		t.Error("invalid method")
+	}
+}
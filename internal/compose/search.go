@@ -0,0 +1,252 @@
+package compose
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const (
+	inArrayPre  = "inarray["
+	inArrayPost = "]"
+	wildcard    = "*"
+)
+
+type (
+	// GetUser is a callback to verify if a user is valid within the backend system
+	GetUser func(string) bool
+
+	// segment is a parsed step of a search layout
+	segment struct {
+		key   string
+		array bool
+		index int
+		field string
+		value string
+		not   bool
+	}
+)
+
+func parseSegment(text string) (segment, error) {
+	l := strings.TrimSpace(text)
+	if l == wildcard {
+		return segment{key: wildcard, index: -1}, nil
+	}
+	if !strings.HasPrefix(l, inArrayPre) || !strings.HasSuffix(l, inArrayPost) {
+		return segment{key: l, index: -1}, nil
+	}
+	s := segment{array: true, index: -1}
+	indexer := strings.TrimSpace(l[len(inArrayPre) : len(l)-len(inArrayPost)])
+	if indexer == "" {
+		return s, nil
+	}
+	if parts := strings.SplitN(indexer, "=", 2); len(parts) == 2 {
+		s.field = strings.TrimSpace(parts[0])
+		s.value = strings.TrimSpace(parts[1])
+		if strings.HasSuffix(s.field, "!") {
+			s.not = true
+			s.field = strings.TrimSpace(strings.TrimSuffix(s.field, "!"))
+		}
+		if s.field == "" {
+			return s, fmt.Errorf("invalid predicate: %s", l)
+		}
+		return s, nil
+	}
+	i, err := strconv.Atoi(indexer)
+	if err != nil {
+		return s, err
+	}
+	s.index = i
+	return s, nil
+}
+
+// matches checks an array element against the predicate of a segment
+func (s segment) matches(idx int, obj interface{}) bool {
+	if s.field == "" {
+		return s.index < 0 || s.index == idx
+	}
+	m, ok := obj.(map[string]interface{})
+	if !ok {
+		return s.not
+	}
+	val, ok := m[s.field]
+	if !ok {
+		return s.not
+	}
+	return (valueString(val) == s.value) != s.not
+}
+
+// valueString gets the text of a JSON scalar (objects and arrays are empty)
+func valueString(obj interface{}) string {
+	switch v := obj.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+func walk(layout []segment, obj interface{}) []interface{} {
+	if len(layout) == 0 {
+		return []interface{}{obj}
+	}
+	current := layout[0]
+	next := layout[1:]
+	var results []interface{}
+	switch {
+	case current.array:
+		arr, ok := obj.([]interface{})
+		if !ok {
+			return nil
+		}
+		for idx, sub := range arr {
+			if current.matches(idx, sub) {
+				results = append(results, walk(next, sub)...)
+			}
+		}
+	case current.key == wildcard:
+		switch v := obj.(type) {
+		case []interface{}:
+			for _, sub := range v {
+				results = append(results, walk(next, sub)...)
+			}
+		case map[string]interface{}:
+			var keys []string
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				results = append(results, walk(next, v[k])...)
+			}
+		}
+	default:
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		sub, ok := m[current.key]
+		if !ok {
+			return nil
+		}
+		if str, ok := sub.(string); ok {
+			// a string ends the search
+			return []interface{}{str}
+		}
+		results = walk(next, sub)
+	}
+	return results
+}
+
+// Search finds every value in JSON data by a layout of keys, wildcards ('*'),
+// and array selectors ('inarray[]', 'inarray[1]', 'inarray[key=value]', 'inarray[key!=value]')
+func Search(layout []string, data []byte) ([]interface{}, error) {
+	var segments []segment
+	for _, l := range layout {
+		s, err := parseSegment(l)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, s)
+	}
+	var obj interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	return walk(segments, obj), nil
+}
+
+// SearchStrings finds every non-empty text value in JSON data (see Search), arrays found are flattened
+func SearchStrings(layout []string, data []byte) ([]string, error) {
+	found, err := Search(layout, data)
+	if err != nil {
+		return nil, err
+	}
+	var results []string
+	for _, f := range found {
+		values := []interface{}{f}
+		if arr, ok := f.([]interface{}); ok {
+			values = arr
+		}
+		for _, v := range values {
+			if str := valueString(v); str != "" {
+				results = append(results, str)
+			}
+		}
+	}
+	return results, nil
+}
+
+// TryGetUser will try and find a user in json output, the first user passing verification is preferred
+func TryGetUser(layout []string, data []byte, verify GetUser) (string, error) {
+	found, err := Search(layout, data)
+	if err != nil {
+		return "", err
+	}
+	var users []string
+	for _, f := range found {
+		if user, ok := f.(string); ok && user != "" {
+			users = append(users, user)
+		}
+	}
+	if len(users) == 0 {
+		return "", fmt.Errorf("unable to find a user")
+	}
+	for _, user := range users {
+		if verify == nil || verify(user) {
+			return user, nil
+		}
+	}
+	return users[0], nil
+}
+
+// findUser tries each layout in order, the first verified user is preferred
+func findUser(layouts [][]string, data []byte, verify GetUser) (string, error) {
+	var first string
+	var err error
+	for _, layout := range layouts {
+		var user string
+		user, err = TryGetUser(layout, data, verify)
+		if err != nil {
+			continue
+		}
+		if verify(user) {
+			return user, nil
+		}
+		if first == "" {
+			first = user
+		}
+	}
+	if first == "" {
+		return "", err
+	}
+	return first, nil
+}
+
+// CheckRequired confirms each requirement finds its value in JSON data (any value when no value is set)
+func CheckRequired(required []core.TokenRequire, data []byte) error {
+	for _, r := range required {
+		found, err := SearchStrings(r.Search, data)
+		if err != nil {
+			return err
+		}
+		met := false
+		for _, f := range found {
+			if r.Value == "" || f == r.Value {
+				met = true
+				break
+			}
+		}
+		if !met {
+			return fmt.Errorf("requirement not met: %s", strings.Join(r.Search, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package compose
+
+import (
+	"testing"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const searchJSON = `{
+	"identities": [
+		{"provider": "github", "username": "other.name"},
+		{"provider": "ldap", "username": "user.name", "active": true, "uid": 1000}
+	],
+	"groups": ["admins", "network"],
+	"profile": {"work": {"name": "work.name"}, "home": {"name": "home.name"}}
+}`
+
+func TestSearch(t *testing.T) {
+	found, err := SearchStrings([]string{"identities", "inarray[provider=ldap]", "username"}, []byte(searchJSON))
+	if err != nil || len(found) != 1 || found[0] != "user.name" {
+		t.Errorf("invalid predicate: %v", found)
+	}
+	found, _ = SearchStrings([]string{"identities", "inarray[provider!=ldap]", "username"}, []byte(searchJSON))
+	if len(found) != 1 || found[0] != "other.name" {
+		t.Errorf("invalid negated predicate: %v", found)
+	}
+	found, _ = SearchStrings([]string{"identities", "inarray[]", "username"}, []byte(searchJSON))
+	if len(found) != 2 {
+		t.Errorf("invalid array: %v", found)
+	}
+	found, _ = SearchStrings([]string{"profile", "*", "name"}, []byte(searchJSON))
+	if len(found) != 2 || found[0] != "home.name" || found[1] != "work.name" {
+		t.Errorf("invalid wildcard: %v", found)
+	}
+	found, _ = SearchStrings([]string{"groups"}, []byte(searchJSON))
+	if len(found) != 2 || found[1] != "network" {
+		t.Errorf("invalid groups: %v", found)
+	}
+	found, _ = SearchStrings([]string{"identities", "*", "active"}, []byte(searchJSON))
+	if len(found) != 1 || found[0] != "true" {
+		t.Errorf("invalid flag: %v", found)
+	}
+	found, _ = SearchStrings([]string{"identities", "inarray[uid=1000]", "username"}, []byte(searchJSON))
+	if len(found) != 1 {
+		t.Errorf("invalid number predicate: %v", found)
+	}
+	found, _ = SearchStrings([]string{"missing", "*"}, []byte(searchJSON))
+	if len(found) != 0 {
+		t.Errorf("nothing should be found: %v", found)
+	}
+	if _, err := Search([]string{"inarray[=a]"}, []byte(searchJSON)); err == nil {
+		t.Error("invalid predicate")
+	}
+	if _, err := Search([]string{"inarray[a]"}, []byte(searchJSON)); err == nil {
+		t.Error("invalid index")
+	}
+}
+
+func TestTryGetUserVerify(t *testing.T) {
+	user, err := TryGetUser([]string{"identities", "*", "username"}, []byte(searchJSON), func(u string) bool {
+		return u == "user.name"
+	})
+	if err != nil || user != "user.name" {
+		t.Error("verified user preferred")
+	}
+	user, err = TryGetUser([]string{"identities", "*", "username"}, []byte(searchJSON), func(u string) bool {
+		return false
+	})
+	if err != nil || user != "other.name" {
+		t.Error("first user")
+	}
+	if _, err := TryGetUser([]string{"groups"}, []byte(searchJSON), nil); err == nil {
+		t.Error("not a user")
+	}
+}
+
+func TestCheckRequired(t *testing.T) {
+	if err := CheckRequired(nil, []byte(searchJSON)); err != nil {
+		t.Error("nothing required")
+	}
+	required := []core.TokenRequire{
+		{Search: []string{"groups"}, Value: "network"},
+		{Search: []string{"identities", "inarray[provider=ldap]", "active"}, Value: "true"},
+		{Search: []string{"profile", "work", "name"}},
+	}
+	if err := CheckRequired(required, []byte(searchJSON)); err != nil {
+		t.Errorf("requirements met: %v", err)
+	}
+	required = append(required, core.TokenRequire{Search: []string{"groups"}, Value: "wifi"})
+	if err := CheckRequired(required, []byte(searchJSON)); err == nil {
+		t.Error("requirement not met")
+	}
+	required = []core.TokenRequire{{Search: []string{"profile", "other"}}}
+	if err := CheckRequired(required, []byte(searchJSON)); err == nil {
+		t.Error("requirement not found")
+	}
+}
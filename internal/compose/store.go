@@ -0,0 +1,297 @@
+package compose
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	bolt "go.etcd.io/bbolt"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const (
+	// storeVersion is the current schema of keys within a store
+	storeVersion = 3
+	versionKey   = "meta=>version"
+	boltFile     = "dotonex.bolt"
+	boltTimeout  = 30 * time.Second
+)
+
+var (
+	boltBucket = []byte("dotonex")
+	// upgrades changes a store from a schema version (index + 1) to the next version
+	upgrades = []func(Store) error{
+		// build state is per host (shared stores), the shared key is removed
+		func(s Store) error {
+			return s.Delete(Wrapper{}.NewKey("last"))
+		},
+		// tokens are validated now, tokens without a validation time do not all expire at once
+		func(s Store) error {
+			prefix := Wrapper{}.NewKey("")
+			var tokenKeys []string
+			if err := s.Each(func(key, value string) error {
+				if !strings.HasPrefix(key, prefix) {
+					return nil
+				}
+				// token keys are MD4 hashes (hex)
+				hash := strings.TrimPrefix(key, prefix)
+				if _, err := hex.DecodeString(hash); err == nil && len(hash) == 32 {
+					tokenKeys = append(tokenKeys, key)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			now := strconv.FormatInt(time.Now().Unix(), 10)
+			for _, key := range tokenKeys {
+				_, ok, err := s.Get(validatedKey(key))
+				if err != nil {
+					return err
+				}
+				if ok {
+					continue
+				}
+				if err := s.Save(validatedKey(key), now); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+)
+
+type (
+	// Store is a key/value store of composition state (tokens, users, server hash)
+	Store interface {
+		Get(key string) (string, bool, error)
+		Save(key, value string) error
+		Delete(key string) error
+		Each(func(key, value string) error) error
+		Close() error
+	}
+
+	buntStore struct {
+		db *buntdb.DB
+	}
+
+	// boltStore opens the file for each operation so it may be shared between processes
+	boltStore struct {
+		path string
+	}
+)
+
+// storePath gets the path of a store (stores default to the repository target)
+func storePath(cfg core.ComposeStore, repo string) string {
+	if cfg.Path != "" {
+		return cfg.Path
+	}
+	name := storeFile
+	if cfg.Type == core.StoreBBolt {
+		name = boltFile
+	}
+	return filepath.Join(repo, bin, name)
+}
+
+// OpenStore opens (and upgrades) the store of a repository
+func OpenStore(cfg core.ComposeStore, repo string) (Store, error) {
+	path := storePath(cfg, repo)
+	var store Store
+	switch cfg.Type {
+	case "", core.StoreBuntDB:
+		db, err := buntdb.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		store = buntStore{db: db}
+	case core.StoreBBolt:
+		b, err := newBoltStore(path)
+		if err != nil {
+			return nil, err
+		}
+		store = b
+	default:
+		return nil, fmt.Errorf("unknown store type: %s", cfg.Type)
+	}
+	if err := upgrade(store); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// storeSchema gets the schema version of a store (stores without a version are version 1)
+func storeSchema(store Store) (int, error) {
+	val, ok, err := store.Get(versionKey)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 1, nil
+	}
+	return strconv.Atoi(val)
+}
+
+func upgrade(store Store) error {
+	version, err := storeSchema(store)
+	if err != nil {
+		return err
+	}
+	if version > storeVersion {
+		return fmt.Errorf("store version %d is newer than supported (%d)", version, storeVersion)
+	}
+	if version == storeVersion {
+		return nil
+	}
+	for _, step := range upgrades[version-1:] {
+		if err := step(store); err != nil {
+			return err
+		}
+	}
+	return store.Save(versionKey, strconv.Itoa(storeVersion))
+}
+
+// Migrate copies every key of a store into another store
+func Migrate(from, to Store) (int, error) {
+	count := 0
+	err := from.Each(func(key, value string) error {
+		count++
+		return to.Save(key, value)
+	})
+	return count, err
+}
+
+func (s buntStore) Get(key string) (string, bool, error) {
+	var val string
+	rErr := s.db.View(func(tx *buntdb.Tx) error {
+		var err error
+		val, err = tx.Get(key)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if rErr == nil {
+		return val, true, nil
+	}
+	if rErr == buntdb.ErrNotFound {
+		return "", false, nil
+	}
+	return "", false, rErr
+}
+
+func (s buntStore) Save(key, value string) error {
+	err := s.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(key, value, nil)
+		return err
+	})
+	return err
+}
+
+func (s buntStore) Delete(key string) error {
+	err := s.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(key)
+		return err
+	})
+	if err == buntdb.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s buntStore) Each(call func(key, value string) error) error {
+	var err error
+	vErr := s.db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("", func(key, value string) bool {
+			err = call(key, value)
+			return err == nil
+		})
+	})
+	if vErr != nil {
+		return vErr
+	}
+	return err
+}
+
+func (s buntStore) Close() error {
+	return s.db.Close()
+}
+
+func newBoltStore(path string) (boltStore, error) {
+	s := boltStore{path: path}
+	err := s.update(func(*bolt.Bucket) error {
+		return nil
+	})
+	return s, err
+}
+
+func (s boltStore) open(readOnly bool) (*bolt.DB, error) {
+	return bolt.Open(s.path, perms, &bolt.Options{Timeout: boltTimeout, ReadOnly: readOnly})
+}
+
+func (s boltStore) view(call func(*bolt.Bucket) error) error {
+	db, err := s.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		return call(tx.Bucket(boltBucket))
+	})
+}
+
+func (s boltStore) update(call func(*bolt.Bucket) error) error {
+	db, err := s.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltBucket)
+		if err != nil {
+			return err
+		}
+		return call(b)
+	})
+}
+
+func (s boltStore) Get(key string) (string, bool, error) {
+	var val []byte
+	err := s.view(func(b *bolt.Bucket) error {
+		if v := b.Get([]byte(key)); v != nil {
+			val = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil || val == nil {
+		return "", false, err
+	}
+	return string(val), true, nil
+}
+
+func (s boltStore) Save(key, value string) error {
+	return s.update(func(b *bolt.Bucket) error {
+		return b.Put([]byte(key), []byte(value))
+	})
+}
+
+func (s boltStore) Delete(key string) error {
+	return s.update(func(b *bolt.Bucket) error {
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s boltStore) Each(call func(key, value string) error) error {
+	return s.view(func(b *bolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			return call(string(k), string(v))
+		})
+	})
+}
+
+func (s boltStore) Close() error {
+	return nil
+}
@@ -0,0 +1,129 @@
+package compose
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func checkStore(t *testing.T, s Store) {
+	if _, ok, err := s.Get("key"); ok || err != nil {
+		t.Error("not found")
+	}
+	if s.Save("key", "value") != nil || s.Save("other", "a") != nil {
+		t.Error("unable to save")
+	}
+	val, ok, err := s.Get("key")
+	if val != "value" || !ok || err != nil {
+		t.Error("invalid value")
+	}
+	if s.Delete("other") != nil || s.Delete("missing") != nil {
+		t.Error("unable to delete")
+	}
+	keys := make(map[string]string)
+	if err := s.Each(func(key, value string) error {
+		keys[key] = value
+		return nil
+	}); err != nil {
+		t.Error("unable to iterate")
+	}
+	if len(keys) != 2 || keys["key"] != "value" || keys[versionKey] != strconv.Itoa(storeVersion) {
+		t.Errorf("invalid keys: %v", keys)
+	}
+}
+
+func TestStores(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenStore(core.ComposeStore{Type: "sqlite"}, dir); err == nil {
+		t.Error("unknown store")
+	}
+	for _, typed := range []string{core.StoreBuntDB, core.StoreBBolt} {
+		s, err := OpenStore(core.ComposeStore{Type: typed, Path: filepath.Join(dir, typed)}, dir)
+		if err != nil {
+			t.Errorf("unable to open %s: %v", typed, err)
+			continue
+		}
+		checkStore(t, s)
+		if err := s.Close(); err != nil {
+			t.Error("unable to close")
+		}
+	}
+}
+
+func TestStoreVersion(t *testing.T) {
+	cfg := core.ComposeStore{Type: core.StoreBBolt, Path: filepath.Join(t.TempDir(), "store")}
+	s, err := newBoltStore(cfg.Path)
+	if err != nil {
+		t.Error("unable to create")
+		return
+	}
+	last := Wrapper{}.NewKey("last")
+	token := Wrapper{}.NewKey("0123456789abcdef0123456789abcdef")
+	if s.Save(last, "abc") != nil || s.Save(token, "user.name") != nil || s.Save(versionKey, "1") != nil {
+		t.Error("unable to save")
+	}
+	if _, err := OpenStore(cfg, ""); err != nil {
+		t.Errorf("unable to upgrade: %v", err)
+	}
+	if _, ok, _ := s.Get(last); ok {
+		t.Error("not upgraded")
+	}
+	if _, ok, _ := s.Get(validatedKey(token)); !ok {
+		t.Error("token validation time not set")
+	}
+	if v, err := storeSchema(s); v != storeVersion || err != nil {
+		t.Error("invalid version")
+	}
+	if s.Save(versionKey, "100") != nil {
+		t.Error("unable to save")
+	}
+	if _, err := OpenStore(cfg, ""); err == nil {
+		t.Error("newer store")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	repo := t.TempDir()
+	writeRepo(t, repo)
+	flags := core.ComposeFlags{Repo: repo, Command: []string{"echo", `{"username": "user.name"}`}}
+	b, err := Open(flags, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	if err := b.Server("hash"); err != nil {
+		t.Errorf("server failed: %v", err)
+	}
+	if err := b.Validate("token", "112233445566"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	b.Close()
+	flags.Store = core.ComposeStore{Type: core.StoreBBolt}
+	flags.Mode = core.ModeMigrate
+	b, err = Open(flags, 0)
+	if err != nil {
+		t.Errorf("unable to open: %v", err)
+		return
+	}
+	if b.Run() == nil {
+		t.Error("no store to migrate from")
+	}
+	b.flags.From = flags.Store
+	if b.Run() == nil {
+		t.Error("unable to migrate to itself")
+	}
+	b.flags.From = core.ComposeStore{Type: core.StoreBuntDB}
+	if err := b.Run(); err != nil {
+		t.Errorf("unable to migrate: %v", err)
+	}
+	val, ok, err := b.store.Get(Wrapper{}.NewKey("user.name"))
+	if val != "token" || !ok || err != nil {
+		t.Error("not migrated")
+	}
+	if err := b.Validate("token", "112233445566"); err != nil {
+		t.Errorf("should validate: %v", err)
+	}
+	b.Close()
+}
@@ -1,13 +1,65 @@
 package core
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
+const (
+	// DynAuthDisconnect sends a Disconnect-Request for affected sessions
+	DynAuthDisconnect = "disconnect"
+	// DynAuthCoA sends a CoA-Request for sessions with a new VLAN (removed sessions are disconnected)
+	DynAuthCoA = "coa"
+)
+
 type (
+	// TokenHTTP is built-in HTTP token validation (used instead of a payload command)
+	TokenHTTP struct {
+		URL     string
+		Query   string
+		Header  string
+		CA      string
+		Timeout int
+		Status  []int
+	}
+
+	// TokenIntrospect is OAuth2 token introspection (RFC 7662) used instead of a payload command
+	TokenIntrospect struct {
+		URL          string
+		ClientID     string
+		ClientSecret string
+		Audience     []string
+		Claims       []string
+		CA           string
+		Timeout      int
+	}
+
+	// GroupLDAP is an LDAP directory of user groups, groups are mapped to VLANs
+	GroupLDAP struct {
+		URL       string
+		StartTLS  bool
+		CA        string
+		BindDN    string
+		Password  string
+		BaseDN    string
+		Filter    string
+		Name      string
+		Attribute string
+		Override  bool
+		Timeout   int
+	}
+
+	// TokenRequire is a search of a token validation response that must find a value (any value if empty)
+	TokenRequire struct {
+		Search []string
+		Value  string
+	}
+
 	// Composition represents compose configurations
 	Composition struct {
 		Debug      bool
@@ -21,6 +73,28 @@ type (
 		Binary     string
 		UserRegex  string
 		Search     []string
+		HTTP       TokenHTTP
+		Introspect TokenIntrospect
+		Require    []TokenRequire
+		LDAP       GroupLDAP
+		Store      ComposeStore
+		Revalidate int
+		Passwords  string
+		Method     string
+		TLS        bool
+		Workers    int
+		Cache      struct {
+			Disable  bool
+			Positive int
+			Negative int
+		}
+	}
+
+	// Client is a RADIUS client (NAS) with its own shared secret
+	Client struct {
+		Address string
+		NASID   string
+		Secret  string
 	}
 
 	// MonitorState is for configuration of state monitoring of internals
@@ -31,17 +105,20 @@ type (
 
 	// Configuration is the configuration definition
 	Configuration struct {
-		Preload    []string
-		Host       string
-		Accounting bool
-		To         int
-		Bind       int
-		NoReject   bool
-		Log        string
-		NoTrace    bool
-		PacketKey  string
-		Compose    Composition
-		Internals  struct {
+		Preload            []string
+		Host               string
+		Accounting         bool
+		To                 int
+		Bind               int
+		NoReject           bool
+		Log                string
+		LogFormat          string
+		NoTrace            bool
+		PacketKey          string
+		RequireMessageAuth bool
+		Clients            []Client
+		Compose            Composition
+		Internals          struct {
 			NoInterrupt    bool
 			NoLogs         bool
 			Logs           int
@@ -51,6 +128,40 @@ type (
 			MaxConnections MonitorState
 			ClientFailures MonitorState
 		}
+		RadSec struct {
+			Enable  bool
+			Bind    int
+			Cert    string
+			Key     string
+			CA      string
+			Timeout int
+			Idle    int
+		}
+		Sessions struct {
+			Enable  bool
+			File    string
+			Timeout int
+		}
+		DynAuth struct {
+			Enable  bool
+			Port    int
+			Timeout int
+			Request string
+			Check   int
+		}
+		Metrics struct {
+			Enable bool
+			Bind   string
+		}
+		Control struct {
+			Enable    bool
+			Directory string
+		}
+		Modules struct {
+			PreAuth    []string
+			Trace      []string
+			Accounting []string
+		}
 		Quit struct {
 			Wait    bool
 			Timeout int
@@ -68,6 +179,65 @@ func (c *Configuration) Dump() {
 	}
 }
 
+// Reload applies the settings of a new configuration that can change while running,
+// the settings that have changed but require a restart are returned
+func (c *Configuration) Reload(next *Configuration) []string {
+	c.Preload = next.Preload
+	c.Compose = next.Compose
+	c.NoReject = next.NoReject
+	c.NoTrace = next.NoTrace
+	c.Log = next.Log
+	c.LogFormat = next.LogFormat
+	c.Internals.Logs = next.Internals.Logs
+	var restart []string
+	current := reflect.ValueOf(c).Elem()
+	updated := reflect.ValueOf(next).Elem()
+	for i := 0; i < current.NumField(); i++ {
+		field := current.Type().Field(i)
+		if field.Name == "Internals" {
+			internals := current.Field(i)
+			for j := 0; j < internals.NumField(); j++ {
+				if !reflect.DeepEqual(internals.Field(j).Interface(), updated.Field(i).Field(j).Interface()) {
+					restart = append(restart, "internals."+strings.ToLower(internals.Type().Field(j).Name))
+				}
+			}
+			continue
+		}
+		if !reflect.DeepEqual(current.Field(i).Interface(), updated.Field(i).Interface()) {
+			restart = append(restart, strings.ToLower(field.Name))
+		}
+	}
+	return restart
+}
+
+// Redacted gets a copy of the configuration with secrets removed
+func (c Configuration) Redacted() Configuration {
+	redacted := "<redacted>"
+	if len(c.PacketKey) > 0 {
+		c.PacketKey = redacted
+	}
+	if len(c.Compose.ServerKey) > 0 {
+		c.Compose.ServerKey = redacted
+	}
+	if len(c.Compose.Introspect.ClientSecret) > 0 {
+		c.Compose.Introspect.ClientSecret = redacted
+	}
+	if len(c.Compose.LDAP.Password) > 0 {
+		c.Compose.LDAP.Password = redacted
+	}
+	if c.Compose.Static && len(c.Compose.Payload) > 0 {
+		// static payloads are token+mac combinations
+		c.Compose.Payload = []string{redacted}
+	}
+	var clients []Client
+	for _, client := range c.Clients {
+		client.Secret = redacted
+		clients = append(clients, client)
+	}
+	c.Clients = clients
+	return c
+}
+
 func defaultString(given, dflt string) string {
 	if len(given) == 0 {
 		return dflt
@@ -79,6 +249,7 @@ func defaultString(given, dflt string) string {
 func (c *Configuration) Defaults(backing []byte) {
 	c.Host = defaultString(c.Host, "localhost")
 	c.Log = defaultString(c.Log, "/var/log/dotonex/")
+	c.LogFormat = defaultString(c.LogFormat, LogFormatText)
 	if c.Bind <= 0 {
 		if c.Accounting {
 			c.Bind = 1813
@@ -86,6 +257,41 @@ func (c *Configuration) Defaults(backing []byte) {
 			c.Bind = 1812
 		}
 	}
+	if c.RadSec.Bind <= 0 {
+		c.RadSec.Bind = 2083
+	}
+	if c.RadSec.Timeout <= 0 {
+		c.RadSec.Timeout = 10
+	}
+	if c.RadSec.Idle <= 0 {
+		c.RadSec.Idle = 300
+	}
+	// each instance gets its own metrics port (e.g. 9812 and 9813)
+	c.Metrics.Bind = defaultString(c.Metrics.Bind, fmt.Sprintf("localhost:%d", c.Bind+8000))
+	c.Control.Directory = defaultString(c.Control.Directory, "/run/dotonex/")
+	if len(c.Modules.PreAuth) == 0 {
+		c.Modules.PreAuth = []string{"usermac"}
+	}
+	if len(c.Modules.Trace) == 0 {
+		c.Modules.Trace = []string{"trace"}
+	}
+	if len(c.Modules.Accounting) == 0 {
+		c.Modules.Accounting = []string{"accounting"}
+	}
+	c.Sessions.File = defaultString(c.Sessions.File, "/var/lib/dotonex/sessions.json")
+	if c.Sessions.Timeout <= 0 {
+		c.Sessions.Timeout = 60
+	}
+	if c.DynAuth.Port <= 0 {
+		c.DynAuth.Port = 3799
+	}
+	if c.DynAuth.Timeout <= 0 {
+		c.DynAuth.Timeout = 5
+	}
+	if c.DynAuth.Check <= 0 {
+		c.DynAuth.Check = 30
+	}
+	c.DynAuth.Request = defaultString(c.DynAuth.Request, DynAuthDisconnect)
 	c.Compose.Repository = defaultString(c.Compose.Repository, "/var/lib/dotonex/config")
 	if c.Compose.Refresh <= 0 {
 		c.Compose.Refresh = 5
@@ -93,6 +299,36 @@ func (c *Configuration) Defaults(backing []byte) {
 	if c.Compose.Timeout <= 0 {
 		c.Compose.Timeout = 30
 	}
+	if c.Compose.HTTP.Timeout <= 0 {
+		c.Compose.HTTP.Timeout = 10
+	}
+	if len(c.Compose.HTTP.Status) == 0 {
+		c.Compose.HTTP.Status = []int{200}
+	}
+	if c.Compose.Introspect.Timeout <= 0 {
+		c.Compose.Introspect.Timeout = 10
+	}
+	if len(c.Compose.Introspect.Claims) == 0 {
+		c.Compose.Introspect.Claims = []string{"username", "sub"}
+	}
+	c.Compose.Store.Type = defaultString(c.Compose.Store.Type, StoreBuntDB)
+	c.Compose.Passwords = defaultString(c.Compose.Passwords, PasswordsShared)
+	c.Compose.Method = defaultString(c.Compose.Method, MethodPEAP)
+	c.Compose.LDAP.Filter = defaultString(c.Compose.LDAP.Filter, "(uid=%s)")
+	c.Compose.LDAP.Name = defaultString(c.Compose.LDAP.Name, "uid")
+	c.Compose.LDAP.Attribute = defaultString(c.Compose.LDAP.Attribute, "memberOf")
+	if c.Compose.LDAP.Timeout <= 0 {
+		c.Compose.LDAP.Timeout = 10
+	}
+	if c.Compose.Workers <= 0 {
+		c.Compose.Workers = 4
+	}
+	if c.Compose.Cache.Positive <= 0 {
+		c.Compose.Cache.Positive = 300
+	}
+	if c.Compose.Cache.Negative <= 0 {
+		c.Compose.Cache.Negative = 30
+	}
 	if c.Internals.Logs <= 0 {
 		c.Internals.Logs = 10
 	}
@@ -119,6 +355,50 @@ func (c Composition) ToEnv(rootEnv []string) []string {
 	if len(c.Search) > 0 {
 		env = newEnv(SearchEnvVariable, strings.Join(c.Search, " "), env, rootEnv)
 	}
+	if c.HTTP.URL != "" {
+		b, err := json.Marshal(c.HTTP)
+		if err == nil {
+			env = newEnv(HTTPEnvVariable, string(b), env, rootEnv)
+		} else {
+			WriteError("unable to set http validation", err)
+		}
+	}
+	if c.Introspect.URL != "" {
+		b, err := json.Marshal(c.Introspect)
+		if err == nil {
+			env = newEnv(IntrospectEnvVariable, string(b), env, rootEnv)
+		} else {
+			WriteError("unable to set token introspection", err)
+		}
+	}
+	if c.LDAP.URL != "" {
+		b, err := json.Marshal(c.LDAP)
+		if err == nil {
+			env = newEnv(LDAPEnvVariable, string(b), env, rootEnv)
+		} else {
+			WriteError("unable to set ldap groups", err)
+		}
+	}
+	if c.Passwords != "" {
+		env = newEnv(PasswordsEnvVariable, c.Passwords, env, rootEnv)
+	}
+	if c.Passwords == PasswordsDerived {
+		env = newEnv(KeyEnvVariable, c.ServerKey, env, rootEnv)
+	}
+	if c.Method != "" {
+		env = newEnv(MethodEnvVariable, c.Method, env, rootEnv)
+	}
+	if c.Revalidate > 0 {
+		env = newEnv(RevalidateEnvVariable, strconv.Itoa(c.Revalidate), env, rootEnv)
+	}
+	if len(c.Require) > 0 {
+		b, err := json.Marshal(c.Require)
+		if err == nil {
+			env = newEnv(RequireEnvVariable, string(b), env, rootEnv)
+		} else {
+			WriteError("unable to set token requirements", err)
+		}
+	}
 	return env
 }
 
@@ -57,6 +57,95 @@ func TestToEnv(t *testing.T) {
 	if envContains(env, "DOTONEX_SEARCH") != "TEST XYZ" {
 		t.Error("searching")
 	}
+	c.HTTP.URL = "https://localhost/user"
+	c.HTTP.Header = "PRIVATE-TOKEN: %s"
+	env = c.ToEnv([]string{"TEST"})
+	if envContains(env, "DOTONEX_HTTP") != `{"URL":"https://localhost/user","Query":"","Header":"PRIVATE-TOKEN: %s","CA":"","Timeout":0,"Status":null}` {
+		t.Error("http validation")
+	}
+	c.Require = []TokenRequire{{Search: []string{"groups"}, Value: "network"}}
+	env = c.ToEnv([]string{"TEST"})
+	if envContains(env, "DOTONEX_REQUIRE") != `[{"Search":["groups"],"Value":"network"}]` {
+		t.Error("token requirements")
+	}
+	c.Revalidate = 60
+	env = c.ToEnv([]string{"TEST"})
+	if envContains(env, "DOTONEX_REVALIDATE") != "60" {
+		t.Error("revalidation")
+	}
+	c.Passwords = PasswordsDerived
+	env = c.ToEnv([]string{"TEST"})
+	if envContains(env, "DOTONEX_PASSWORDS") != "derived" {
+		t.Error("passwords")
+	}
+	if envContains(env, "DOTONEX_KEY") != "" {
+		t.Error("no key")
+	}
+	c.ServerKey = "key"
+	env = c.ToEnv([]string{"TEST"})
+	if envContains(env, "DOTONEX_KEY") != "key" {
+		t.Error("derived passwords key")
+	}
+	c.Method = MethodTTLSPAP
+	env = c.ToEnv([]string{"TEST"})
+	if envContains(env, "DOTONEX_METHOD") != "ttls-pap" {
+		t.Error("method")
+	}
+}
+
+func TestReload(t *testing.T) {
+	c := &Configuration{Host: "localhost", Bind: 1812, Log: "/var/log/dotonex/"}
+	c.Internals.Logs = 10
+	next := &Configuration{Host: "localhost", Bind: 1812, Log: "/tmp/", NoReject: true}
+	next.Internals.Logs = 5
+	next.Compose.Static = true
+	next.Compose.Payload = []string{"a"}
+	if len(c.Reload(next)) != 0 {
+		t.Error("no restart required")
+	}
+	if c.Log != "/tmp/" || !c.NoReject || c.Internals.Logs != 5 || !c.Compose.Static || c.Compose.Payload[0] != "a" {
+		t.Error("changes not applied")
+	}
+	next = &Configuration{Host: "other", Bind: 1813, Log: "/tmp/"}
+	next.Internals.Logs = 5
+	next.Internals.LifeCheck = 1
+	restart := c.Reload(next)
+	if len(restart) != 3 || restart[0] != "host" || restart[1] != "bind" || restart[2] != "internals.lifecheck" {
+		t.Errorf("invalid restart: %v", restart)
+	}
+	if c.Host != "localhost" || c.Bind != 1812 || c.NoReject {
+		t.Error("only live changes should apply")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := Configuration{PacketKey: "key"}
+	c.Compose.ServerKey = "server"
+	c.Compose.Introspect.ClientSecret = "client"
+	c.Compose.LDAP.Password = "ldap"
+	c.Compose.Payload = []string{"token/mac"}
+	c.Clients = []Client{{Address: "10.0.0.1", Secret: "secret"}}
+	r := c.Redacted()
+	if r.PacketKey != "<redacted>" || r.Compose.ServerKey != "<redacted>" || r.Clients[0].Secret != "<redacted>" || r.Clients[0].Address != "10.0.0.1" {
+		t.Error("invalid redaction")
+	}
+	if r.Compose.Introspect.ClientSecret != "<redacted>" || r.Compose.LDAP.Password != "<redacted>" {
+		t.Error("client secret should be redacted")
+	}
+	if r.Compose.Payload[0] != "token/mac" {
+		t.Error("managed payload is a command")
+	}
+	if c.PacketKey != "key" || c.Clients[0].Secret != "secret" {
+		t.Error("original should not change")
+	}
+	c.Compose.Static = true
+	if c.Redacted().Compose.Payload[0] != "<redacted>" {
+		t.Error("static payload should be redacted")
+	}
+	c = Configuration{}
+	if c.Redacted().PacketKey != "" {
+		t.Error("empty should stay empty")
+	}
 }
 
 func TestDefaults(t *testing.T) {
@@ -68,18 +157,60 @@ func TestDefaults(t *testing.T) {
 	if c.Log != "/var/log/dotonex/" {
 		t.Error("invalid log dir")
 	}
+	if c.LogFormat != "text" {
+		t.Error("invalid log format")
+	}
+	if c.Metrics.Bind != "localhost:9812" {
+		t.Error("invalid metrics bind")
+	}
+	if c.Control.Directory != "/run/dotonex/" {
+		t.Error("invalid control directory")
+	}
+	if len(c.Modules.PreAuth) != 1 || c.Modules.PreAuth[0] != "usermac" {
+		t.Error("invalid preauth modules")
+	}
+	if len(c.Modules.Trace) != 1 || c.Modules.Trace[0] != "trace" {
+		t.Error("invalid trace modules")
+	}
+	if len(c.Modules.Accounting) != 1 || c.Modules.Accounting[0] != "accounting" {
+		t.Error("invalid accounting modules")
+	}
 	if c.Compose.Timeout != 30 {
 		t.Error("invalid timeout")
 	}
 	if c.Compose.Refresh != 5 {
 		t.Error("invalid refresh")
 	}
+	if c.Compose.Workers != 4 {
+		t.Error("invalid workers")
+	}
+	if c.Compose.Cache.Positive != 300 || c.Compose.Cache.Negative != 30 {
+		t.Error("invalid cache")
+	}
+	if c.Compose.HTTP.Timeout != 10 || len(c.Compose.HTTP.Status) != 1 || c.Compose.HTTP.Status[0] != 200 {
+		t.Error("invalid http validation")
+	}
+	if c.Compose.Introspect.Timeout != 10 || len(c.Compose.Introspect.Claims) != 2 || c.Compose.Introspect.Claims[1] != "sub" {
+		t.Error("invalid introspection")
+	}
+	if c.Compose.LDAP.Filter != "(uid=%s)" || c.Compose.LDAP.Name != "uid" || c.Compose.LDAP.Attribute != "memberOf" || c.Compose.LDAP.Timeout != 10 {
+		t.Error("invalid ldap")
+	}
 	if c.Accounting {
 		t.Error("wrong type")
 	}
 	if c.Bind != 1812 {
 		t.Error("invalid port")
 	}
+	if c.RadSec.Bind != 2083 || c.RadSec.Timeout != 10 || c.RadSec.Idle != 300 {
+		t.Error("invalid radsec port")
+	}
+	if c.Sessions.File != "/var/lib/dotonex/sessions.json" || c.Sessions.Timeout != 60 {
+		t.Error("invalid session defaults")
+	}
+	if c.DynAuth.Port != 3799 || c.DynAuth.Timeout != 5 || c.DynAuth.Check != 30 || c.DynAuth.Request != DynAuthDisconnect {
+		t.Error("invalid dynauth defaults")
+	}
 	if c.Internals.Logs != 10 {
 		t.Error("invalid log buffer")
 	}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// ControlOK indicates a control command succeeded
+	ControlOK = "ok"
+	// ControlError indicates a control command failed
+	ControlError   = "error"
+	controlSuffix  = ".sock"
+	controlTimeout = 30 * time.Second
+)
+
+// ControlSocket gets the control socket path of an instance
+func ControlSocket(dir, instance string) string {
+	return filepath.Join(dir, instance+controlSuffix)
+}
+
+// WriteControl writes a control response (status line then body)
+func WriteControl(w io.Writer, body string, err error) error {
+	status := ControlOK
+	if err != nil {
+		status = ControlError
+		body = err.Error()
+	}
+	body = strings.TrimSuffix(body, "\n")
+	if len(body) > 0 {
+		body = body + "\n"
+	}
+	_, werr := io.WriteString(w, fmt.Sprintf("%s\n%s", status, body))
+	return werr
+}
+
+// ReadControl reads a control response
+func ReadControl(r io.Reader) (string, error) {
+	reader := bufio.NewReader(r)
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("invalid control response: %v", err)
+	}
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	body := string(b)
+	switch strings.TrimSpace(status) {
+	case ControlOK:
+		return body, nil
+	case ControlError:
+		return "", fmt.Errorf("%s", strings.TrimSpace(body))
+	}
+	return "", fmt.Errorf("unknown control status: %s", status)
+}
+
+// ControlRequest sends a command to a runner control socket
+func ControlRequest(socket string, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("no command given")
+	}
+	conn, err := net.DialTimeout("unix", socket, controlTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(controlTimeout)); err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(conn, strings.Join(args, " ")+"\n"); err != nil {
+		return "", err
+	}
+	return ReadControl(conn)
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestControlSocket(t *testing.T) {
+	if ControlSocket("/run/dotonex/", "proxy") != "/run/dotonex/proxy.sock" {
+		t.Error("invalid socket")
+	}
+}
+
+func TestControlResponse(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteControl(&b, "result", nil); err != nil {
+		t.Error("should write")
+	}
+	if b.String() != "ok\nresult\n" {
+		t.Error("invalid response")
+	}
+	res, err := ReadControl(&b)
+	if err != nil || res != "result\n" {
+		t.Error("invalid read")
+	}
+	b.Reset()
+	if err := WriteControl(&b, "", nil); err != nil {
+		t.Error("should write")
+	}
+	if b.String() != "ok\n" {
+		t.Error("invalid empty response")
+	}
+	b.Reset()
+	if err := WriteControl(&b, "result", fmt.Errorf("failed")); err != nil {
+		t.Error("should write")
+	}
+	if _, err := ReadControl(&b); err == nil || err.Error() != "failed" {
+		t.Error("should fail")
+	}
+	b.Reset()
+	b.WriteString("bad\n")
+	if _, err := ReadControl(&b); err == nil {
+		t.Error("invalid status")
+	}
+}
@@ -1,9 +1,11 @@
 package core
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -15,16 +17,34 @@ type (
 		Debug     bool
 	}
 
+	// ComposeStore is the storage (type and optional path) of composition state
+	ComposeStore struct {
+		Type string
+		Path string
+	}
+
 	// ComposeFlags are config backend arguments
 	ComposeFlags struct {
-		Mode    string
-		Repo    string
-		Hash    string
-		MAC     string
-		Token   string
-		Search  []string
-		Debug   bool
-		Command []string
+		Mode       string
+		Repo       string
+		Hash       string
+		MAC        string
+		Token      string
+		User       string
+		Format     string
+		Search     []string
+		Debug      bool
+		Command    []string
+		HTTP       TokenHTTP
+		Introspect TokenIntrospect
+		Require    []TokenRequire
+		LDAP       GroupLDAP
+		Store      ComposeStore
+		From       ComposeStore
+		Revalidate int
+		Passwords  string
+		Key        string
+		Method     string
 	}
 )
 
@@ -38,10 +58,16 @@ const (
 	macFlag      = "mac"
 	tokenFlag    = "token"
 	hashFlag     = "hash"
+	userFlag     = "user"
+	formatFlag   = "format"
+	storeFlag    = "store"
+	fromFlag     = "from"
 	// InstanceConfig indicates a configuration file of instance type
 	InstanceConfig = ".conf"
 	// ModeValidate tells configuration to validate a user+mac
 	ModeValidate = "validate"
+	// ModeTLS tells configuration to validate a certificate (TLS) user+mac
+	ModeTLS = "tls"
 	// ModeServer will configure the baseline server requirements
 	ModeServer = "server"
 	// ModeFetch will indicate changes should be fetched remotely
@@ -52,12 +78,56 @@ const (
 	ModeRebuild = "rebuild"
 	// ModeMAC will check for MAC validity
 	ModeMAC = "mac"
+	// ModeRevoke will remove a user's validated tokens
+	ModeRevoke = "revoke"
+	// ModePassword will show a user's password
+	ModePassword = "password"
+	// ModeInventory will list users and MAB devices
+	ModeInventory = "inventory"
+	// ModeMigrate will copy a store into the configured store
+	ModeMigrate = "migrate"
+	// ModeGeneration will show the store generation (changes when users are added or revoked)
+	ModeGeneration = "generation"
+	// StoreBuntDB is a buntdb store (the default)
+	StoreBuntDB = "buntdb"
+	// StoreBBolt is a bbolt store
+	StoreBBolt = "bbolt"
+	// PasswordsShared uses the server key as every user's password
+	PasswordsShared = "shared"
+	// PasswordsDerived derives each user's password from the server key
+	PasswordsDerived = "derived"
+	// PasswordsRepository reads each user's password hash from the repository
+	PasswordsRepository = "repository"
+	// MethodPEAP is PEAP with MSCHAPv2 user logins
+	MethodPEAP = "peap"
+	// MethodTTLSMSCHAPv2 is TTLS with MSCHAPv2 user logins
+	MethodTTLSMSCHAPv2 = "ttls-mschapv2"
+	// MethodTTLSPAP is TTLS with PAP user logins (requires derived passwords)
+	MethodTTLSPAP = "ttls-pap"
 	// DebugEnvOn indicates environment variable debugging is on for processes
 	DebugEnvOn = "true"
 	// DebugEnvVariable is the environment variable to indicate debug state
 	DebugEnvVariable = "DOTONEX_DEBUG"
 	// SearchEnvVariable is an underlying method to set how the configurator search for keys
 	SearchEnvVariable = "DOTONEX_SEARCH"
+	// HTTPEnvVariable is the HTTP token validation settings (json) for the configurator
+	HTTPEnvVariable = "DOTONEX_HTTP"
+	// IntrospectEnvVariable is the token introspection settings (json) for the configurator
+	IntrospectEnvVariable = "DOTONEX_INTROSPECT"
+	// LDAPEnvVariable is the LDAP group settings (json) for the configurator
+	LDAPEnvVariable = "DOTONEX_LDAP"
+	// RequireEnvVariable is the token requirements (json) for the configurator
+	RequireEnvVariable = "DOTONEX_REQUIRE"
+	// PasswordsEnvVariable is how user passwords are set (shared, derived, or repository)
+	PasswordsEnvVariable = "DOTONEX_PASSWORDS"
+	// KeyEnvVariable is the server key derived passwords are derived from (never stored)
+	KeyEnvVariable = "DOTONEX_KEY"
+	// MethodEnvVariable is the default user login method (peap, ttls-mschapv2, or ttls-pap)
+	MethodEnvVariable = "DOTONEX_METHOD"
+	// RevalidateEnvVariable is the minutes before a validated token is validated again
+	RevalidateEnvVariable = "DOTONEX_REVALIDATE"
+	// TokenEnvVariable is the token to validate (when not given as a flag)
+	TokenEnvVariable = "DOTONEX_TOKEN"
 )
 
 // Debugging writes potential information from composition if debugging is one
@@ -83,6 +153,10 @@ func (c ComposeFlags) Args() []string {
 	flags = argIfSet(tokenFlag, c.Token, flags)
 	flags = argIfSet(hashFlag, c.Hash, flags)
 	flags = argIfSet(macFlag, c.MAC, flags)
+	flags = argIfSet(userFlag, c.User, flags)
+	flags = argIfSet(formatFlag, c.Format, flags)
+	flags = argIfSet(storeFlag, c.Store.String(), flags)
+	flags = argIfSet(fromFlag, c.From.String(), flags)
 	if len(c.Command) > 0 {
 		flags = append(flags, c.Command...)
 	}
@@ -96,6 +170,10 @@ func GetComposeFlags() ComposeFlags {
 	mac := flag.String(macFlag, "", "MAC address")
 	hash := flag.String(hashFlag, "", "server hash")
 	token := flag.String(tokenFlag, "", "token to validate")
+	user := flag.String(userFlag, "", "user to revoke (or check)")
+	format := flag.String(formatFlag, "", "inventory format (table or json)")
+	store := flag.String(storeFlag, "", "store as type[:path]")
+	from := flag.String(fromFlag, "", "store to migrate from as type[:path]")
 	flag.Parse()
 	args := flag.Args()
 	debug := os.Getenv(DebugEnvVariable) == DebugEnvOn
@@ -104,14 +182,84 @@ func GetComposeFlags() ComposeFlags {
 	if searchEnv != "" {
 		search = strings.Split(searchEnv, " ")
 	}
+	var validator TokenHTTP
+	httpEnv := strings.TrimSpace(os.Getenv(HTTPEnvVariable))
+	if httpEnv != "" {
+		if err := json.Unmarshal([]byte(httpEnv), &validator); err != nil {
+			WriteError("invalid http validation settings", err)
+		}
+	}
+	var introspect TokenIntrospect
+	introspectEnv := strings.TrimSpace(os.Getenv(IntrospectEnvVariable))
+	if introspectEnv != "" {
+		if err := json.Unmarshal([]byte(introspectEnv), &introspect); err != nil {
+			WriteError("invalid token introspection settings", err)
+		}
+	}
+	var required []TokenRequire
+	requireEnv := strings.TrimSpace(os.Getenv(RequireEnvVariable))
+	if requireEnv != "" {
+		if err := json.Unmarshal([]byte(requireEnv), &required); err != nil {
+			WriteError("invalid token requirements", err)
+		}
+	}
+	var groups GroupLDAP
+	ldapEnv := strings.TrimSpace(os.Getenv(LDAPEnvVariable))
+	if ldapEnv != "" {
+		if err := json.Unmarshal([]byte(ldapEnv), &groups); err != nil {
+			WriteError("invalid ldap group settings", err)
+		}
+	}
+	revalidate := 0
+	revalidateEnv := strings.TrimSpace(os.Getenv(RevalidateEnvVariable))
+	if revalidateEnv != "" {
+		i, err := strconv.Atoi(revalidateEnv)
+		if err != nil {
+			WriteError("invalid revalidation interval", err)
+		}
+		revalidate = i
+	}
+	if *token == "" {
+		*token = os.Getenv(TokenEnvVariable)
+	}
 	return ComposeFlags{Mode: *mode,
-		Repo:    *repo,
-		MAC:     *mac,
-		Token:   *token,
-		Hash:    *hash,
-		Search:  search,
-		Debug:   debug,
-		Command: args}
+		Repo:       *repo,
+		MAC:        *mac,
+		Token:      *token,
+		User:       *user,
+		Format:     *format,
+		Hash:       *hash,
+		Search:     search,
+		Debug:      debug,
+		HTTP:       validator,
+		Introspect: introspect,
+		Require:    required,
+		LDAP:       groups,
+		Store:      ParseComposeStore(*store),
+		From:       ParseComposeStore(*from),
+		Revalidate: revalidate,
+		Passwords:  strings.TrimSpace(os.Getenv(PasswordsEnvVariable)),
+		Key:        os.Getenv(KeyEnvVariable),
+		Method:     strings.TrimSpace(os.Getenv(MethodEnvVariable)),
+		Command:    args}
+}
+
+// ParseComposeStore reads a store as type[:path]
+func ParseComposeStore(text string) ComposeStore {
+	parts := strings.SplitN(strings.TrimSpace(text), ":", 2)
+	s := ComposeStore{Type: parts[0]}
+	if len(parts) == 2 {
+		s.Path = parts[1]
+	}
+	return s
+}
+
+// String converts a store to type[:path]
+func (s ComposeStore) String() string {
+	if s.Path == "" {
+		return s.Type
+	}
+	return fmt.Sprintf("%s:%s", s.Type, s.Path)
 }
 
 // Valid will check the basics for valid config backend flags
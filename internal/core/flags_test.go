@@ -39,6 +39,26 @@ func TestComposeArgs(t *testing.T) {
 	}
 }
 
+func TestComposeStore(t *testing.T) {
+	s := ParseComposeStore("")
+	if s.Type != "" || s.Path != "" || s.String() != "" {
+		t.Error("empty store")
+	}
+	s = ParseComposeStore("bbolt")
+	if s.Type != "bbolt" || s.Path != "" || s.String() != "bbolt" {
+		t.Error("store type only")
+	}
+	s = ParseComposeStore("bbolt:/a/b:c")
+	if s.Type != "bbolt" || s.Path != "/a/b:c" || s.String() != "bbolt:/a/b:c" {
+		t.Error("store with path")
+	}
+	c := ComposeFlags{Store: s}
+	args := c.Args()
+	if len(args) != 2 || args[0] != "--store" || args[1] != "bbolt:/a/b:c" {
+		t.Error("store args")
+	}
+}
+
 func TestComposeValid(t *testing.T) {
 	c := ComposeFlags{}
 	if c.Valid() {
@@ -1,24 +1,93 @@
 package core
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// LogFormatText writes human readable log lines
+	LogFormatText = "text"
+	// LogFormatJSON writes log records as JSON objects (one per line)
+	LogFormatJSON = "json"
 )
 
 var (
+	// debugging changes while running (e.g. by the control socket)
+	debugLock = &sync.RWMutex{}
 	debugging = false
 	instance  = ""
+	name      = ""
+	// the format changes on reload while logging
+	formatLock = &sync.RWMutex{}
+	jsonLogs   = false
+)
+
+type (
+	logRecord struct {
+		Time     string   `json:"time"`
+		Level    string   `json:"level"`
+		Instance string   `json:"instance,omitempty"`
+		Message  string   `json:"message"`
+		Details  []string `json:"details,omitempty"`
+	}
 )
 
 // ConfigureLogging will configure the underlying logging options
 // (this should be called at startup)
 func ConfigureLogging(dbg bool, inst string) {
-	debugging = dbg
+	SetDebug(dbg)
+	name = inst
 	if len(inst) > 0 {
 		instance = fmt.Sprintf("- %s - ", inst)
 	}
 }
 
+// SetDebug changes debug logging while running
+func SetDebug(dbg bool) {
+	debugLock.Lock()
+	defer debugLock.Unlock()
+	debugging = dbg
+}
+
+// Debugging indicates if debug logging is enabled
+func Debugging() bool {
+	debugLock.RLock()
+	defer debugLock.RUnlock()
+	return debugging
+}
+
+// ConfigureLogFormat sets the output format of log messages
+func ConfigureLogFormat(format string) error {
+	var useJSON bool
+	switch format {
+	case "", LogFormatText:
+	case LogFormatJSON:
+		useJSON = true
+	default:
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	formatLock.Lock()
+	defer formatLock.Unlock()
+	jsonLogs = useJSON
+	return nil
+}
+
+// JSONLogs indicates if logs should be written as JSON
+func JSONLogs() bool {
+	formatLock.RLock()
+	defer formatLock.RUnlock()
+	return jsonLogs
+}
+
+// LogTime is the timestamp format used in log outputs
+func LogTime(t time.Time) string {
+	return t.Format("2006-01-02T15:04:05.000")
+}
+
 func init() {
 	log.SetFlags(0)
 }
@@ -48,13 +117,20 @@ func WriteError(message string, err error) {
 
 // WriteDebug logs debugging messages
 func WriteDebug(message string, messages ...string) {
-	if !debugging {
+	if !Debugging() {
 		return
 	}
 	write("DEBUG", message, messages...)
 }
 
 func write(cat, message string, messages ...string) {
+	if JSONLogs() {
+		b, err := json.Marshal(logRecord{Time: LogTime(time.Now()), Level: cat, Instance: name, Message: message, Details: messages})
+		if err == nil {
+			log.Print(string(b))
+			return
+		}
+	}
 	category := ""
 	vars := ""
 	category = fmt.Sprintf("[%s] ", cat)
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestConfigureLogFormat(t *testing.T) {
+	defer ConfigureLogFormat(LogFormatText)
+	if err := ConfigureLogFormat(""); err != nil || JSONLogs() {
+		t.Error("empty is text")
+	}
+	if err := ConfigureLogFormat(LogFormatJSON); err != nil || !JSONLogs() {
+		t.Error("should be json")
+	}
+	if err := ConfigureLogFormat(LogFormatText); err != nil || JSONLogs() {
+		t.Error("should be text")
+	}
+	if err := ConfigureLogFormat("xml"); err == nil {
+		t.Error("invalid format")
+	}
+}
+
+func TestSetDebugWhileLogging(t *testing.T) {
+	defer SetDebug(false)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			SetDebug(i%2 == 0)
+		}
+		done <- true
+	}()
+	for i := 0; i < 100; i++ {
+		WriteDebug("debugging")
+	}
+	<-done
+}
+
+func TestLogFormatWhileLogging(t *testing.T) {
+	defer ConfigureLogFormat(LogFormatText)
+	done := make(chan bool)
+	go func() {
+		for _, format := range []string{LogFormatJSON, LogFormatText, LogFormatJSON} {
+			if err := ConfigureLogFormat(format); err != nil {
+				t.Error("valid format")
+			}
+		}
+		done <- true
+	}()
+	for i := 0; i < 10; i++ {
+		WriteInfo("logging")
+	}
+	<-done
+}
@@ -52,6 +52,15 @@ func GetTokenFromLogin(input string) (string, string) {
 	return parts[0], strings.Join(parts[1:], userLogin)
 }
 
+// GetUserFromLogin gets the user of a user+vlan login without a token (a certificate login)
+func GetUserFromLogin(input string) string {
+	user := strings.Split(input, userVLANLogin)[0]
+	if strings.Contains(user, userLogin) {
+		return ""
+	}
+	return user
+}
+
 // CleanMAC will clean a MAC and check that it is valid
 func CleanMAC(value string) (string, bool) {
 	str := ""
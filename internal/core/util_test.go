@@ -42,6 +42,15 @@ func TestGetTokenFrom(t *testing.T) {
 	}
 }
 
+func TestGetUserFromLogin(t *testing.T) {
+	if GetUserFromLogin("user") != "user" || GetUserFromLogin("user@vlan.t") != "user" {
+		t.Error("user is valid")
+	}
+	if GetUserFromLogin("user:token") != "" || GetUserFromLogin("user:token@vlan.t") != "" {
+		t.Error("not a certificate login")
+	}
+}
+
 func TestNewUserLogin(t *testing.T) {
 	if NewUserLogin("abc", "xyz") != "abc:xyz" {
 		t.Error("invalid login")
@@ -0,0 +1,112 @@
+package runner
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2866"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const (
+	// how long a request is remembered to detect retransmits
+	retransmitWindow = 30 * time.Second
+)
+
+type (
+	accountingRecord struct {
+		authenticator [authenticatorLength]byte
+		response      []byte
+		received      time.Time
+	}
+
+	// retransmits tracks recently answered accounting requests by source and identifier
+	retransmits struct {
+		lock    *sync.Mutex
+		records map[string]accountingRecord
+	}
+)
+
+func newRetransmits() *retransmits {
+	return &retransmits{lock: &sync.Mutex{}, records: make(map[string]accountingRecord)}
+}
+
+func retransmitKey(addr *net.UDPAddr, id byte) string {
+	source := "unknown"
+	if addr != nil {
+		source = addr.String()
+	}
+	return fmt.Sprintf("%s/%d", source, id)
+}
+
+func (r *retransmits) get(key string, authenticator [authenticatorLength]byte) ([]byte, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	record, ok := r.records[key]
+	if !ok || record.authenticator != authenticator {
+		return nil, false
+	}
+	if time.Since(record.received) > retransmitWindow {
+		return nil, false
+	}
+	return record.response, true
+}
+
+func (r *retransmits) set(key string, authenticator [authenticatorLength]byte, response []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := time.Now()
+	for k, v := range r.records {
+		if now.Sub(v.received) > retransmitWindow {
+			delete(r.records, k)
+		}
+	}
+	r.records[key] = accountingRecord{authenticator: authenticator, response: response, received: now}
+}
+
+// HandleAccounting validates, records, and responds to an accounting request
+func HandleAccounting(ctx *Context, b []byte, addr *net.UDPAddr, write writeBack) {
+	packet := NewClientPacket(b, addr)
+	ctx.packet(packet)
+	if packet.Error != nil {
+		if ctx.Debugging() {
+			core.WriteError("unable to parse accounting packet", packet.Error)
+		}
+		return
+	}
+	if packet.Packet.Code != radius.CodeAccountingRequest {
+		// other packets can not be authenticated (and are not accounting)
+		core.WriteWarn(fmt.Sprintf("dropping %s on accounting (%s)", packet.Packet.Code, retransmitKey(addr, packet.Packet.Identifier)))
+		return
+	}
+	if err := ctx.checkSecret(packet); err != nil {
+		core.WriteError("invalid radius client", err)
+		return
+	}
+	if !radius.IsAuthenticRequest(b, packet.Packet.Secret) {
+		core.WriteWarn(fmt.Sprintf("invalid accounting request authenticator (%s)", retransmitKey(addr, packet.Packet.Identifier)))
+		return
+	}
+	key := retransmitKey(addr, packet.Packet.Identifier)
+	response, seen := ctx.acctSeen.get(key, packet.Packet.Authenticator)
+	if seen {
+		core.WriteDebug("accounting retransmit")
+		incMetric(retransmitMetric)
+	} else {
+		incMetric(accountingMetric, "status", rfc2866.AcctStatusType_Get(packet.Packet).String())
+		ctx.Account(packet)
+		resp, err := encodeResponse(packet.Packet.Response(radius.CodeAccountingResponse), packet.Packet.Authenticator)
+		if err != nil {
+			core.WriteError("unable to encode accounting response", err)
+			return
+		}
+		response = resp
+		ctx.acctSeen.set(key, packet.Packet.Authenticator, response)
+	}
+	if write != nil {
+		write(response)
+	}
+}
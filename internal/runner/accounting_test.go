@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+func newAccountingPacket(t *testing.T, secret []byte) []byte {
+	p := radius.New(radius.CodeAccountingRequest, secret)
+	if err := rfc2865.UserName_AddString(p, "user"); err != nil {
+		t.Error("unable to add user name")
+	}
+	b, err := p.Encode()
+	if err != nil {
+		t.Error("unable to encode")
+	}
+	return b
+}
+
+func TestHandleAccounting(t *testing.T) {
+	m := &MockModule{}
+	ctx := &Context{secret: []byte("secret"), hasAcct: true, acct: m.Account, acctSeen: newRetransmits()}
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1000}
+	b := newAccountingPacket(t, ctx.secret)
+	var responses [][]byte
+	write := func(r []byte) {
+		responses = append(responses, r)
+	}
+	HandleAccounting(ctx, b, addr, write)
+	if m.acct != 1 || len(responses) != 1 {
+		t.Error("should account and respond")
+	}
+	if !radius.IsAuthenticResponse(responses[0], b, ctx.secret) {
+		t.Error("invalid response authenticator")
+	}
+	HandleAccounting(ctx, b, addr, write)
+	if m.acct != 1 || len(responses) != 2 {
+		t.Error("retransmit should respond only")
+	}
+	if !bytes.Equal(responses[0], responses[1]) {
+		t.Error("retransmit response should match")
+	}
+	HandleAccounting(ctx, b, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1001}, write)
+	if m.acct != 2 || len(responses) != 3 {
+		t.Error("different source is not a retransmit")
+	}
+	HandleAccounting(ctx, newAccountingPacket(t, []byte("other")), addr, write)
+	if m.acct != 2 || len(responses) != 3 {
+		t.Error("invalid authenticator should drop")
+	}
+	_, p := getPacket(t)
+	HandleAccounting(ctx, p.Buffer, addr, write)
+	if m.acct != 2 || len(responses) != 3 {
+		t.Error("non-accounting requests should drop")
+	}
+}
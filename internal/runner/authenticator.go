@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"fmt"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2869"
+)
+
+const (
+	headerLength        = 20
+	authenticatorLength = 16
+)
+
+// find the offset of the message-authenticator value within a wire packet
+func findMessageAuthenticator(b []byte) (int, bool) {
+	if len(b) < headerLength {
+		return 0, false
+	}
+	offset := headerLength
+	for offset+2 <= len(b) {
+		length := int(b[offset+1])
+		if length < 2 || offset+length > len(b) {
+			return 0, false
+		}
+		if radius.Type(b[offset]) == rfc2869.MessageAuthenticator_Type {
+			if length != authenticatorLength+2 {
+				return 0, false
+			}
+			return offset + 2, true
+		}
+		offset += length
+	}
+	return 0, false
+}
+
+func hmacMD5(b, secret []byte) []byte {
+	h := hmac.New(md5.New, secret)
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// checkMessageAuthenticator validates an RFC 3579 Message-Authenticator when present
+// (or when required) within a wire packet
+func checkMessageAuthenticator(b, secret []byte, required bool) error {
+	idx, ok := findMessageAuthenticator(b)
+	if !ok {
+		if required {
+			return fmt.Errorf("message authenticator required")
+		}
+		return nil
+	}
+	given := make([]byte, authenticatorLength)
+	copy(given, b[idx:idx+authenticatorLength])
+	zeroed := make([]byte, len(b))
+	copy(zeroed, b)
+	for i := idx; i < idx+authenticatorLength; i++ {
+		zeroed[i] = 0
+	}
+	if !hmac.Equal(given, hmacMD5(zeroed, secret)) {
+		return fmt.Errorf("message authenticator mismatch")
+	}
+	return nil
+}
+
+// encodeResponse signs a response (to the given request) with a Message-Authenticator and encodes it
+func encodeResponse(response *radius.Packet, request [authenticatorLength]byte) ([]byte, error) {
+	response.Set(rfc2869.MessageAuthenticator_Type, make([]byte, authenticatorLength))
+	copy(response.Authenticator[:], request[:])
+	b, err := response.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	response.Set(rfc2869.MessageAuthenticator_Type, hmacMD5(b, response.Secret))
+	return response.Encode()
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+)
+
+func newSignedRequest(t *testing.T, secret []byte) []byte {
+	p := radius.New(radius.CodeAccessRequest, secret)
+	if err := rfc2865.UserName_AddString(p, "user"); err != nil {
+		t.Error("unable to add user name")
+	}
+	p.Set(rfc2869.MessageAuthenticator_Type, make([]byte, authenticatorLength))
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Error("unable to marshal")
+	}
+	p.Set(rfc2869.MessageAuthenticator_Type, hmacMD5(b, secret))
+	b, err = p.Encode()
+	if err != nil {
+		t.Error("unable to encode")
+	}
+	return b
+}
+
+func TestCheckMessageAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	b := newSignedRequest(t, secret)
+	if err := checkMessageAuthenticator(b, secret, true); err != nil {
+		t.Error("valid authenticator")
+	}
+	if err := checkMessageAuthenticator(b, []byte("other"), false); err == nil {
+		t.Error("wrong secret")
+	}
+	b[len(b)-1] = b[len(b)-1] + 1
+	if err := checkMessageAuthenticator(b, secret, false); err == nil {
+		t.Error("tampered authenticator")
+	}
+	p := radius.New(radius.CodeAccessRequest, secret)
+	raw, err := p.Encode()
+	if err != nil {
+		t.Error("unable to encode")
+	}
+	if err := checkMessageAuthenticator(raw, secret, false); err != nil {
+		t.Error("not required")
+	}
+	if err := checkMessageAuthenticator(raw, secret, true); err == nil {
+		t.Error("required")
+	}
+}
+
+func TestEncodeResponse(t *testing.T) {
+	secret := []byte("secret")
+	req := newSignedRequest(t, secret)
+	p, err := radius.Parse(req, secret)
+	if err != nil {
+		t.Error("unable to parse")
+	}
+	b, err := encodeResponse(p.Response(radius.CodeAccessReject), p.Authenticator)
+	if err != nil {
+		t.Error("unable to encode")
+	}
+	if !radius.IsAuthenticResponse(b, req, secret) {
+		t.Error("invalid response authenticator")
+	}
+	if _, ok := findMessageAuthenticator(b); !ok {
+		t.Error("no message authenticator")
+	}
+	check := make([]byte, len(b))
+	copy(check, b)
+	copy(check[4:20], req[4:20])
+	if err := checkMessageAuthenticator(check, secret, true); err != nil {
+		t.Error("invalid message authenticator")
+	}
+}
+
+func TestRequireMessageAuth(t *testing.T) {
+	ctx, p := getPacket(t)
+	if ctx.authorize(p) != successCode {
+		t.Error("not required")
+	}
+	ctx, p = getPacket(t)
+	ctx.msgAuth = true
+	if ctx.authorize(p) != badAuthCode {
+		t.Error("required")
+	}
+	ctx.noReject = false
+	written := false
+	HandlePreAuth(ctx, p.Buffer, nil, func(b []byte) {
+		written = true
+	})
+	if written {
+		t.Error("should drop")
+	}
+	ctx, _ = getPacket(t)
+	ctx.msgAuth = true
+	if ctx.authorize(NewClientPacket(newSignedRequest(t, ctx.secret), nil)) != successCode {
+		t.Error("signed request")
+	}
+}
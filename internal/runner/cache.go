@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	cacheMetric = "dotonex_preauth_cache_total"
+)
+
+var (
+	decisions = newDecisionCache()
+)
+
+type (
+	cachedDecision struct {
+		valid   bool
+		expires time.Time
+	}
+
+	flight struct {
+		wait  *sync.WaitGroup
+		valid bool
+	}
+
+	// flights merges concurrent calls for the same key into one call
+	flights struct {
+		lock  *sync.Mutex
+		calls map[string]*flight
+	}
+
+	// decisionCache remembers pre-auth backend results (valid for positive, invalid for negative)
+	decisionCache struct {
+		lock     *sync.Mutex
+		positive time.Duration
+		negative time.Duration
+		entries  map[string]cachedDecision
+	}
+)
+
+func init() {
+	defineMetric(cacheMetric, counterMetric, "pre-auth decision cache lookups by result")
+}
+
+func newDecisionCache() *decisionCache {
+	return &decisionCache{lock: &sync.Mutex{}, entries: make(map[string]cachedDecision)}
+}
+
+func newFlights() *flights {
+	return &flights{lock: &sync.Mutex{}, calls: make(map[string]*flight)}
+}
+
+func (f *flights) do(key string, call func() bool) bool {
+	f.lock.Lock()
+	if existing, ok := f.calls[key]; ok {
+		f.lock.Unlock()
+		existing.wait.Wait()
+		return existing.valid
+	}
+	current := &flight{wait: &sync.WaitGroup{}}
+	current.wait.Add(1)
+	f.calls[key] = current
+	f.lock.Unlock()
+	current.valid = call()
+	f.lock.Lock()
+	delete(f.calls, key)
+	f.lock.Unlock()
+	current.wait.Done()
+	return current.valid
+}
+
+// decisionKey hashes a lookup, tokens are not kept (in the clear) for the lifetime of entries
+func decisionKey(user, token, mac string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s", user, token, mac)))
+	return hex.EncodeToString(sum[:])
+}
+
+// configure changes the cache lifetimes (<= 0 disables), all entries are cleared
+func (c *decisionCache) configure(positive, negative time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.positive = positive
+	c.negative = negative
+	c.entries = make(map[string]cachedDecision)
+}
+
+func (c *decisionCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[string]cachedDecision)
+}
+
+func (c *decisionCache) get(key string) (bool, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.positive <= 0 && c.negative <= 0 {
+		return false, false
+	}
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	result := "miss"
+	if ok {
+		result = "hit"
+	}
+	incMetric(cacheMetric, "result", result)
+	return entry.valid, ok
+}
+
+func (c *decisionCache) set(key string, valid bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ttl := c.negative
+	if valid {
+		ttl = c.positive
+	}
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	for k, v := range c.entries {
+		if now.After(v.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedDecision{valid: valid, expires: now.Add(ttl)}
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecisionCache(t *testing.T) {
+	c := newDecisionCache()
+	c.set("a", true)
+	if _, ok := c.get("a"); ok {
+		t.Error("disabled cache")
+	}
+	c.configure(time.Minute, time.Minute)
+	c.set("a", true)
+	c.set("b", false)
+	if valid, ok := c.get("a"); !ok || !valid {
+		t.Error("should be cached valid")
+	}
+	if valid, ok := c.get("b"); !ok || valid {
+		t.Error("should be cached invalid")
+	}
+	if _, ok := c.get("c"); ok {
+		t.Error("not cached")
+	}
+	c.clear()
+	if _, ok := c.get("a"); ok {
+		t.Error("cleared")
+	}
+	c.configure(time.Minute, 0)
+	c.set("a", true)
+	c.set("b", false)
+	if _, ok := c.get("a"); !ok {
+		t.Error("positive cached")
+	}
+	if _, ok := c.get("b"); ok {
+		t.Error("negative not cached")
+	}
+	c.configure(time.Minute, -1*time.Millisecond)
+	c.entries["b"] = cachedDecision{valid: false, expires: time.Now().Add(-1 * time.Second)}
+	if _, ok := c.get("b"); ok {
+		t.Error("expired")
+	}
+	if len(c.entries) != 0 {
+		t.Error("expired should be removed")
+	}
+	key := decisionKey("user", "token", "mac")
+	if len(key) != 64 || strings.Contains(key, "token") || key != decisionKey("user", "token", "mac") {
+		t.Errorf("invalid key: %s", key)
+	}
+	if key == decisionKey("user", "other", "mac") || decisionKey("a/b", "c", "d") == decisionKey("a", "b/c", "d") {
+		t.Error("keys should differ")
+	}
+}
+
+func TestFlights(t *testing.T) {
+	f := newFlights()
+	calls := 0
+	release := make(chan bool)
+	started := make(chan bool)
+	results := make(chan bool)
+	go func() {
+		results <- f.do("key", func() bool {
+			calls++
+			started <- true
+			<-release
+			return true
+		})
+	}()
+	<-started
+	for i := 0; i < 3; i++ {
+		go func() {
+			results <- f.do("key", func() bool {
+				calls++
+				return false
+			})
+		}()
+	}
+	// wait for the merged calls to be waiting
+	for {
+		time.Sleep(10 * time.Millisecond)
+		f.lock.Lock()
+		waiting := len(f.calls)
+		f.lock.Unlock()
+		if waiting == 1 {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	release <- true
+	for i := 0; i < 4; i++ {
+		if !<-results {
+			t.Error("should share the first result")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("invalid calls: %d", calls)
+	}
+	if f.do("key", func() bool { return false }) {
+		t.Error("should call again")
+	}
+}
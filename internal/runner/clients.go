@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+type (
+	// client is a known NAS (by address and/or NAS-Identifier) and its secret
+	client struct {
+		network *net.IPNet
+		nasID   string
+		secret  []byte
+	}
+)
+
+func newClient(c core.Client) (client, error) {
+	result := client{nasID: c.NASID, secret: []byte(c.Secret)}
+	if len(c.Secret) == 0 {
+		return result, fmt.Errorf("no secret for client")
+	}
+	if len(c.Address) == 0 && len(c.NASID) == 0 {
+		return result, fmt.Errorf("client requires an address or nasid")
+	}
+	if len(c.Address) > 0 {
+		cidr := c.Address
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return result, fmt.Errorf("invalid client address: %s", cidr)
+			}
+			if ip.To4() != nil {
+				cidr = cidr + "/32"
+			} else {
+				cidr = cidr + "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return result, err
+		}
+		result.network = network
+	}
+	return result, nil
+}
+
+func (c client) matches(addr *net.UDPAddr, p *radius.Packet) bool {
+	if c.network != nil {
+		if addr == nil || !c.network.Contains(addr.IP) {
+			return false
+		}
+	}
+	if len(c.nasID) > 0 {
+		if rfc2865.NASIdentifier_GetString(p) != c.nasID {
+			return false
+		}
+	}
+	return true
+}
+
+// secretFor resolves the shared secret for a packet from a given client address
+// (the packetkey is only used for every client when no clients are configured)
+func (ctx *Context) secretFor(addr *net.UDPAddr, p *radius.Packet) []byte {
+	if len(ctx.clients) == 0 {
+		return ctx.secret
+	}
+	for _, c := range ctx.clients {
+		if c.matches(addr, p) {
+			return c.secret
+		}
+	}
+	return nil
+}
+
+// nasSecret resolves the shared secret of the NAS a session is on
+func (ctx *Context) nasSecret(s Session) []byte {
+	p := radius.New(radius.CodeDisconnectRequest, nil)
+	if s.NASID != "" && s.NASID != unknownNAS {
+		if err := rfc2865.NASIdentifier_SetString(p, s.NASID); err != nil {
+			return nil
+		}
+	}
+	var addr *net.UDPAddr
+	if ip := net.ParseIP(s.NASIP); ip != nil {
+		addr = &net.UDPAddr{IP: ip}
+	}
+	return ctx.secretFor(addr, p)
+}
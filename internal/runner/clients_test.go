@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"net"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func TestNewClient(t *testing.T) {
+	if _, err := newClient(core.Client{Address: "127.0.0.1"}); err == nil {
+		t.Error("no secret")
+	}
+	if _, err := newClient(core.Client{Secret: "abc"}); err == nil {
+		t.Error("no address or nasid")
+	}
+	if _, err := newClient(core.Client{Secret: "abc", Address: "a.b.c"}); err == nil {
+		t.Error("invalid address")
+	}
+	if _, err := newClient(core.Client{Secret: "abc", Address: "10.0.0.0/33"}); err == nil {
+		t.Error("invalid cidr")
+	}
+	c, err := newClient(core.Client{Secret: "abc", Address: "10.0.0.1"})
+	if err != nil || c.network.String() != "10.0.0.1/32" {
+		t.Error("valid address")
+	}
+	c, err = newClient(core.Client{Secret: "abc", Address: "::1"})
+	if err != nil || c.network.String() != "::1/128" {
+		t.Error("valid address")
+	}
+	if _, err := newClient(core.Client{Secret: "abc", NASID: "switch"}); err != nil {
+		t.Error("valid nasid")
+	}
+}
+
+func TestSecretFor(t *testing.T) {
+	ctx := &Context{secret: []byte("default")}
+	p := radius.New(radius.CodeAccessRequest, nil)
+	addr := &net.UDPAddr{IP: net.ParseIP("10.1.0.5"), Port: 1000}
+	if string(ctx.secretFor(addr, p)) != "default" {
+		t.Error("no clients uses packet key")
+	}
+	for _, obj := range []core.Client{
+		{Address: "10.0.0.0/16", Secret: "net"},
+		{NASID: "guest", Secret: "guest"},
+		{Address: "10.1.0.0/16", NASID: "switch", Secret: "both"},
+	} {
+		c, err := newClient(obj)
+		if err != nil {
+			t.Error("invalid client")
+		}
+		ctx.clients = append(ctx.clients, c)
+	}
+	if ctx.secretFor(addr, p) != nil {
+		t.Error("unknown client")
+	}
+	if string(ctx.secretFor(&net.UDPAddr{IP: net.ParseIP("10.0.2.1")}, p)) != "net" {
+		t.Error("network client")
+	}
+	if err := rfc2865.NASIdentifier_SetString(p, "switch"); err != nil {
+		t.Error("unable to set nas id")
+	}
+	if string(ctx.secretFor(addr, p)) != "both" {
+		t.Error("network and nasid client")
+	}
+	if ctx.secretFor(nil, p) != nil {
+		t.Error("nasid only does not match address client")
+	}
+	if err := rfc2865.NASIdentifier_SetString(p, "guest"); err != nil {
+		t.Error("unable to set nas id")
+	}
+	if string(ctx.secretFor(nil, p)) != "guest" {
+		t.Error("nasid client")
+	}
+}
+
+func TestUnknownClient(t *testing.T) {
+	ctx, p := getPacket(t)
+	c, err := newClient(core.Client{Address: "10.0.0.1", Secret: "secret"})
+	if err != nil {
+		t.Error("invalid client")
+	}
+	ctx.clients = append(ctx.clients, c)
+	m := &MockModule{}
+	ctx.hasPre = true
+	ctx.pre = m.Pre
+	ctx.hasTrace = true
+	ctx.trace = m.Trace
+	if ctx.authorize(p) != badSecretCode {
+		t.Error("unknown client")
+	}
+	if m.pre != 0 || m.trace != 0 {
+		t.Error("unknown clients are not given to modules")
+	}
+	_, p = getPacket(t)
+	p.ClientAddr = &net.UDPAddr{IP: net.ParseIP("10.0.0.1")}
+	if ctx.authorize(p) != successCode {
+		t.Error("known client")
+	}
+	if m.pre != 1 || m.trace != 1 {
+		t.Error("known clients are given to modules")
+	}
+}
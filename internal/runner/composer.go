@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+type (
+	// composer performs composition operations (in-process or by calling a binary)
+	composer interface {
+		MAC(mac string) error
+		Validate(token, mac string) error
+		TLS(user, mac string) error
+		Server(hash string) error
+		Fetch() error
+		Build() error
+		Generation() (string, error)
+	}
+
+	// execComposer calls a composition binary with flags for each operation
+	execComposer struct {
+		cfg     core.Composition
+		env     []string
+		timeout time.Duration
+	}
+)
+
+func (b execComposer) execute(flags core.ComposeFlags) error {
+	_, err := b.output(flags)
+	return err
+}
+
+// output runs the binary, getting its stdout
+func (b execComposer) output(flags core.ComposeFlags) (string, error) {
+	flags.Repo = b.cfg.Repository
+	flags.Store = b.cfg.Store
+	env := b.env
+	if flags.Token != "" {
+		// arguments are visible to anyone listing processes, the token is passed in the environment
+		if len(env) == 0 {
+			env = os.Environ()
+		}
+		env = append(env[:len(env):len(env)], fmt.Sprintf("%s=%s", core.TokenEnvVariable, flags.Token))
+		flags.Token = ""
+	}
+	arguments := flags.Args()
+
+	if b.cfg.Debug {
+		core.WriteInfo(fmt.Sprintf("running: %v", arguments))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, b.cfg.Binary, arguments...)
+	if len(env) > 0 {
+		cmd.Env = env
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("script timeout")
+	}
+	str := stderr.String()
+	if len(str) > 0 {
+		core.WriteInfo("stderr")
+		core.WriteInfo(str)
+	}
+	result := strings.TrimSpace(string(out))
+	if b.cfg.Debug && len(result) > 0 {
+		core.WriteInfo("stdout")
+		core.WriteInfo(result)
+	}
+	return result, err
+}
+
+func (b execComposer) MAC(mac string) error {
+	return b.execute(core.ComposeFlags{Mode: core.ModeMAC, MAC: mac})
+}
+
+func (b execComposer) Validate(token, mac string) error {
+	return b.execute(core.ComposeFlags{Mode: core.ModeValidate, MAC: mac, Token: token, Command: b.cfg.Payload})
+}
+
+func (b execComposer) TLS(user, mac string) error {
+	return b.execute(core.ComposeFlags{Mode: core.ModeTLS, MAC: mac, User: user})
+}
+
+func (b execComposer) Server(hash string) error {
+	return b.execute(core.ComposeFlags{Mode: core.ModeServer, Hash: hash})
+}
+
+func (b execComposer) Fetch() error {
+	return b.execute(core.ComposeFlags{Mode: core.ModeFetch})
+}
+
+func (b execComposer) Build() error {
+	return b.execute(core.ComposeFlags{Mode: core.ModeBuild})
+}
+
+// Generation asks the binary, the store is the binary's own
+func (b execComposer) Generation() (string, error) {
+	return b.output(core.ComposeFlags{Mode: core.ModeGeneration})
+}
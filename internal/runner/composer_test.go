@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func TestExecComposer(t *testing.T) {
+	c := execComposer{timeout: time.Second}
+	c.cfg.Binary = "true"
+	if c.MAC("112233445566") != nil || c.Validate("token", "112233445566") != nil {
+		t.Error("should succeed")
+	}
+	c.cfg.Binary = "false"
+	if c.Server("hash") == nil || c.Build() == nil {
+		t.Error("should fail")
+	}
+	slow := filepath.Join(t.TempDir(), "slow")
+	if err := os.WriteFile(slow, []byte("#!/bin/sh\nexec sleep 5\n"), 0700); err != nil {
+		t.Error("unable to write script")
+	}
+	c.cfg.Binary = slow
+	c.timeout = 10 * time.Millisecond
+	if c.Fetch() == nil {
+		t.Error("should timeout")
+	}
+	token := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(token, []byte("#!/bin/sh\ncase \"$*\" in *secret*) exit 1;; esac\n[ \"$DOTONEX_TOKEN\" = \"secret\" ]\n"), 0700); err != nil {
+		t.Error("unable to write script")
+	}
+	c.cfg.Binary = token
+	c.timeout = time.Second
+	if c.Validate("secret", "112233445566") != nil {
+		t.Error("token should be in the environment")
+	}
+	c.env = []string{"PATH=/usr/bin:/bin"}
+	if c.Validate("secret", "112233445566") != nil || len(c.env) != 1 {
+		t.Error("token should be added to the environment")
+	}
+	if c.Validate("other", "112233445566") == nil {
+		t.Error("invalid token")
+	}
+	generation := filepath.Join(t.TempDir(), "generation")
+	if err := os.WriteFile(generation, []byte("#!/bin/sh\n[ \"$1 $2\" = \"--mode generation\" ] && echo 5\n"), 0700); err != nil {
+		t.Error("unable to write script")
+	}
+	c.cfg.Binary = generation
+	if gen, err := c.Generation(); err != nil || gen != "5" {
+		t.Errorf("invalid generation: %s (%v)", gen, err)
+	}
+	s := script{composer: c, cfg: core.Composition{}}
+	if s.MAC("112233445566") {
+		t.Error("script should fail")
+	}
+}
@@ -0,0 +1,168 @@
+package runner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const (
+	controlTimeout = 30 * time.Second
+)
+
+type (
+	// ControlCommand handles a control request, the result is returned to the caller
+	ControlCommand func(args []string) (string, error)
+
+	controlHandler struct {
+		help    string
+		command ControlCommand
+	}
+
+	// Control is the runner's admin control socket
+	Control struct {
+		lock     *sync.Mutex
+		commands map[string]controlHandler
+	}
+)
+
+// NewControl creates a control socket handler with the default commands
+func NewControl() *Control {
+	c := &Control{lock: &sync.Mutex{}, commands: make(map[string]controlHandler)}
+	c.Register("help", "list available commands", c.help)
+	c.Register("debug", "get or set debug logging (on|off)", DebugCommand)
+	c.Register("modules", "list the registered modules", func(args []string) (string, error) {
+		var lines []string
+		for _, kind := range []string{"preauth", "trace", "accounting"} {
+			lines = append(lines, fmt.Sprintf("%s: %s", kind, strings.Join(Modules()[kind], " ")))
+		}
+		return strings.Join(lines, "\n"), nil
+	})
+	c.Register("fetch", "force a compose fetch and build", func(args []string) (string, error) {
+		if err := Refresh(); err != nil {
+			return "", err
+		}
+		return "fetch and build completed", nil
+	})
+	c.Register("sessions", "list the active sessions (of a mac or user)", SessionsCommand)
+	c.Register("disconnect", "disconnect the sessions of a mac (dynauth)", DisconnectCommand)
+	return c
+}
+
+// Register adds a command to the control socket
+func (c *Control) Register(name, help string, command ControlCommand) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.commands[name] = controlHandler{help: help, command: command}
+}
+
+func (c *Control) help(args []string) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var names []string
+	for name := range c.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s: %s", name, c.commands[name].help))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// DebugCommand gets or changes the debug logging state
+func DebugCommand(args []string) (string, error) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "on":
+			core.SetDebug(true)
+		case "off":
+			core.SetDebug(false)
+		default:
+			return "", fmt.Errorf("unknown debug setting: %s", args[0])
+		}
+	}
+	state := "off"
+	if core.Debugging() {
+		state = "on"
+	}
+	return fmt.Sprintf("debug: %s", state), nil
+}
+
+func (c *Control) run(line string) (string, error) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return "", fmt.Errorf("no command given")
+	}
+	c.lock.Lock()
+	handler, ok := c.commands[args[0]]
+	c.lock.Unlock()
+	if !ok {
+		return "", fmt.Errorf("unknown command: %s", args[0])
+	}
+	return handler.command(args[1:])
+}
+
+func (c *Control) handle(conn net.Conn) {
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(controlTimeout)); err != nil {
+		core.WriteError("control deadline", err)
+		return
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		core.WriteError("control read", err)
+		return
+	}
+	core.WriteDebug("control command", line)
+	result, err := c.run(line)
+	if err := core.WriteControl(conn, result, err); err != nil {
+		core.WriteError("control write", err)
+	}
+}
+
+// Listen opens the control socket, replacing any stale socket
+func (c *Control) Listen(socket string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0755); err != nil {
+		return nil, err
+	}
+	if core.PathExists(socket) {
+		if err := os.Remove(socket); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Serve handles control connections until the listener is closed
+func (c *Control) Serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			core.WriteError("control accept", err)
+			continue
+		}
+		go c.handle(conn)
+	}
+}
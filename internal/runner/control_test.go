@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func TestControlRun(t *testing.T) {
+	c := NewControl()
+	c.Register("echo", "echo arguments", func(args []string) (string, error) {
+		return strings.Join(args, ","), nil
+	})
+	res, err := c.run("echo a b\n")
+	if err != nil || res != "a,b" {
+		t.Error("invalid echo")
+	}
+	if _, err := c.run("none"); err == nil {
+		t.Error("unknown command")
+	}
+	if _, err := c.run(" "); err == nil {
+		t.Error("no command")
+	}
+	res, err = c.run("help")
+	if err != nil || !strings.Contains(res, "echo: echo arguments") || !strings.HasPrefix(res, "debug:") {
+		t.Error("invalid help")
+	}
+}
+
+func TestControlDebug(t *testing.T) {
+	defer core.SetDebug(false)
+	c := NewControl()
+	res, err := c.run("debug on")
+	if err != nil || res != "debug: on" || !core.Debugging() {
+		t.Error("debug should be on")
+	}
+	res, err = c.run("debug")
+	if err != nil || res != "debug: on" {
+		t.Error("debug should still be on")
+	}
+	if _, err := c.run("debug maybe"); err == nil {
+		t.Error("invalid debug")
+	}
+	res, err = c.run("debug off")
+	if err != nil || res != "debug: off" || core.Debugging() {
+		t.Error("debug should be off")
+	}
+}
+
+func TestControlSocket(t *testing.T) {
+	c := NewControl()
+	c.Register("fail", "always fails", func(args []string) (string, error) {
+		return "", fmt.Errorf("failure")
+	})
+	socket := filepath.Join(t.TempDir(), "sub", "test.sock")
+	listener, err := c.Listen(socket)
+	if err != nil {
+		t.Fatal("unable to listen")
+	}
+	defer listener.Close()
+	go c.Serve(listener)
+	res, err := core.ControlRequest(socket, []string{"debug"})
+	if err != nil || res != "debug: off\n" {
+		t.Error("invalid request")
+	}
+	if _, err := core.ControlRequest(socket, []string{"fail"}); err == nil || err.Error() != "failure" {
+		t.Error("should fail")
+	}
+	if _, err := c.Listen(socket); err != nil {
+		t.Error("stale socket should be replaced")
+	}
+}
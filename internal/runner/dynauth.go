@@ -0,0 +1,266 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2868"
+	"layeh.com/radius/rfc3576"
+	"voidedtech.com/dotonex/internal/compose"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const (
+	vlanTunnelType = rfc2868.TunnelType(13)
+)
+
+var (
+	dynAuthLock = new(sync.Mutex)
+	dynAuth     *dynAuthClient
+)
+
+type (
+	// dynAuthClient sends Dynamic Authorization (RFC 5176) requests to the NAS of a session
+	dynAuthClient struct {
+		port     int
+		timeout  time.Duration
+		coa      bool
+		file     string
+		secret   func(Session) []byte
+		assigned compose.Assignments
+	}
+
+	// sessionChange is a request to send for a session a build has changed
+	sessionChange struct {
+		session Session
+		code    radius.Code
+		vlan    string
+		reason  string
+	}
+)
+
+// ConfigureDynAuth enables dynamic authorization requests for tracked sessions, the
+// current assignments (if built) are what later builds are compared against
+func ConfigureDynAuth(ctx *Context, c *core.Configuration) error {
+	switch c.DynAuth.Request {
+	case core.DynAuthDisconnect, core.DynAuthCoA:
+	default:
+		return fmt.Errorf("unknown dynauth request: %s", c.DynAuth.Request)
+	}
+	client := &dynAuthClient{
+		port:    c.DynAuth.Port,
+		timeout: time.Duration(c.DynAuth.Timeout) * time.Second,
+		coa:     c.DynAuth.Request == core.DynAuthCoA,
+		file:    compose.AssignmentsFile(c.Compose.Repository),
+		secret:  ctx.nasSecret,
+	}
+	if core.PathExists(client.file) {
+		assigned, err := compose.ReadAssignments(client.file)
+		if err != nil {
+			return err
+		}
+		client.assigned = assigned
+	}
+	dynAuthLock.Lock()
+	defer dynAuthLock.Unlock()
+	dynAuth = client
+	return nil
+}
+
+func currentDynAuth() *dynAuthClient {
+	dynAuthLock.Lock()
+	defer dynAuthLock.Unlock()
+	return dynAuth
+}
+
+func (d *dynAuthClient) send(s Session, code radius.Code, vlan string) error {
+	ip := net.ParseIP(s.NASIP)
+	if ip == nil {
+		return fmt.Errorf("no nas address for session: %s", s.ID)
+	}
+	secret := d.secret(s)
+	if len(secret) == 0 {
+		return fmt.Errorf("no shared secret for nas: %s", s.NASIP)
+	}
+	p := radius.New(code, secret)
+	if err := rfc2865.UserName_SetString(p, s.User); err != nil {
+		return err
+	}
+	if s.CallingStation != "" {
+		if err := rfc2865.CallingStationID_SetString(p, s.CallingStation); err != nil {
+			return err
+		}
+	}
+	if s.ID != "" {
+		if err := rfc2866.AcctSessionID_SetString(p, s.ID); err != nil {
+			return err
+		}
+	}
+	if ip.To4() != nil {
+		if err := rfc2865.NASIPAddress_Set(p, ip); err != nil {
+			return err
+		}
+	}
+	if s.NASID != "" && s.NASID != unknownNAS {
+		if err := rfc2865.NASIdentifier_SetString(p, s.NASID); err != nil {
+			return err
+		}
+	}
+	if code == radius.CodeCoARequest {
+		if err := rfc2868.TunnelType_Set(p, 0, vlanTunnelType); err != nil {
+			return err
+		}
+		if err := rfc2868.TunnelMediumType_Set(p, 0, rfc2868.TunnelMediumType_Value_IEEE802); err != nil {
+			return err
+		}
+		if err := rfc2868.TunnelPrivateGroupID_SetString(p, 0, vlan); err != nil {
+			return err
+		}
+	}
+	timeout, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	resp, err := radius.Exchange(timeout, p, net.JoinHostPort(ip.String(), strconv.Itoa(d.port)))
+	if err != nil {
+		return err
+	}
+	switch resp.Code {
+	case radius.CodeDisconnectACK, radius.CodeCoAACK:
+		return nil
+	}
+	if cause, err := rfc3576.ErrorCause_Lookup(resp); err == nil {
+		return fmt.Errorf("%s (%s)", resp.Code, cause)
+	}
+	return fmt.Errorf("%s", resp.Code)
+}
+
+func (d *dynAuthClient) request(change sessionChange) error {
+	err := d.send(change.session, change.code, change.vlan)
+	result := "ACK"
+	if err != nil {
+		result = "FAILED"
+		core.WriteError(fmt.Sprintf("dynauth %s failed for %s", change.code, change.session.User), err)
+	}
+	s := change.session
+	kv := keyValueStore{}
+	kv.add("Result", result)
+	kv.add("Request", change.code.String())
+	kv.add("Reason", change.reason)
+	kv.add("Session", s.ID)
+	kv.add("User-Name", s.User)
+	kv.add("Calling-Station-Id", s.CallingStation)
+	kv.add("NAS-IPAddress", s.NASIP)
+	logPluginMessages("dynauth", kv)
+	incMetric(dynAuthMetric, "code", change.code.String(), "result", strings.ToLower(result))
+	return err
+}
+
+// changes finds the sessions that are no longer allowed (or are on another VLAN) between builds,
+// sessions of logins that were not in the previous build are left alone
+func (d *dynAuthClient) changes(previous, next compose.Assignments, active []Session) []sessionChange {
+	var result []sessionChange
+	for _, s := range active {
+		old, ok := previous.Find(s.User)
+		if !ok {
+			continue
+		}
+		mac, _ := core.CleanMAC(s.CallingStation)
+		change := sessionChange{session: s, code: radius.CodeDisconnectRequest}
+		current, ok := next.Find(s.User)
+		switch {
+		case !ok:
+			change.reason = "LOGINREMOVED"
+		case old.Allows(mac) && !current.Allows(mac):
+			change.reason = "MACREMOVED"
+		case old.VLAN != current.VLAN:
+			change.reason = "VLANCHANGED"
+			if d.coa {
+				change.code = radius.CodeCoARequest
+				change.vlan = current.VLAN
+			}
+		default:
+			continue
+		}
+		result = append(result, change)
+	}
+	return result
+}
+
+// CheckAssignments sends requests for the active sessions a build has changed
+func CheckAssignments() {
+	client := currentDynAuth()
+	if client == nil || !core.PathExists(client.file) {
+		return
+	}
+	next, err := compose.ReadAssignments(client.file)
+	if err != nil {
+		core.WriteError("unable to read assignments", err)
+		return
+	}
+	dynAuthLock.Lock()
+	previous := client.assigned
+	client.assigned = next
+	dynAuthLock.Unlock()
+	if previous == nil {
+		return
+	}
+	for _, change := range client.changes(previous, next, Sessions(nil)) {
+		// failures are logged, the session stays as-is until it re-authenticates
+		client.request(change)
+	}
+}
+
+// Disconnect sends a Disconnect-Request for each active session of a MAC
+func Disconnect(mac string) ([]Session, error) {
+	cleaned, ok := core.CleanMAC(mac)
+	if !ok {
+		return nil, fmt.Errorf("invalid mac: %s", mac)
+	}
+	client := currentDynAuth()
+	if client == nil {
+		return nil, fmt.Errorf("dynauth is not enabled")
+	}
+	found := Sessions(func(s Session) bool {
+		calling, ok := core.CleanMAC(s.CallingStation)
+		return ok && calling == cleaned
+	})
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no sessions for %s", cleaned)
+	}
+	var disconnected []Session
+	var failed []string
+	for _, s := range found {
+		if err := client.request(sessionChange{session: s, code: radius.CodeDisconnectRequest, reason: "MANUAL"}); err != nil {
+			failed = append(failed, fmt.Sprintf("%s on %s: %v", s.ID, s.NASIP, err))
+			continue
+		}
+		disconnected = append(disconnected, s)
+	}
+	if len(failed) > 0 {
+		return disconnected, fmt.Errorf("unable to disconnect %s", strings.Join(failed, ", "))
+	}
+	return disconnected, nil
+}
+
+// DisconnectCommand disconnects the sessions of a MAC
+func DisconnectCommand(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("disconnect requires a mac")
+	}
+	disconnected, err := Disconnect(args[0])
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, s := range disconnected {
+		lines = append(lines, fmt.Sprintf("disconnected %s (%s) on %s", s.User, s.CallingStation, s.NASIP))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,194 @@
+package runner
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2868"
+	"voidedtech.com/dotonex/internal/compose"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+// fakeNAS answers dynamic authorization requests (NAK for the "nak" session)
+func fakeNAS(t *testing.T, secret string) (int, chan *radius.Packet) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	received := make(chan *radius.Packet, 10)
+	go func() {
+		var buffer [radius.MaxPacketLength]byte
+		for {
+			n, addr, err := conn.ReadFromUDP(buffer[0:])
+			if err != nil {
+				return
+			}
+			if !radius.IsAuthenticRequest(buffer[0:n], []byte(secret)) {
+				continue
+			}
+			p, err := radius.Parse(buffer[0:n], []byte(secret))
+			if err != nil {
+				continue
+			}
+			received <- p
+			code := radius.CodeDisconnectACK
+			if p.Code == radius.CodeCoARequest {
+				code = radius.CodeCoAACK
+			}
+			if rfc2866.AcctSessionID_GetString(p) == "nak" {
+				code = radius.CodeDisconnectNAK
+			}
+			b, err := p.Response(code).Encode()
+			if err != nil {
+				continue
+			}
+			if _, err := conn.WriteToUDP(b, addr); err != nil {
+				return
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port, received
+}
+
+func writeAssignments(t *testing.T, repo string, assignments []compose.Assignment) {
+	b, err := json.Marshal(assignments)
+	if err != nil {
+		t.Error("unable to marshal assignments")
+	}
+	file := compose.AssignmentsFile(repo)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		t.Error("unable to create bin")
+	}
+	if err := os.WriteFile(file, b, 0600); err != nil {
+		t.Error("unable to write assignments")
+	}
+}
+
+func addSession(s Session) {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	sessions[sessionKey(s.NASIP, s.ID)] = &s
+}
+
+func configureDynAuth(t *testing.T, request string) (string, chan *radius.Packet) {
+	port, received := fakeNAS(t, "secret")
+	repo := t.TempDir()
+	writeAssignments(t, repo, []compose.Assignment{
+		{Login: "AABBCCDDEEFF", VLAN: "2", MACs: []string{"aabbccddeeff"}},
+		{Login: "user.name:token", VLAN: "1", MACs: []string{"112233445566", "665544332211"}},
+		{Login: "other.name:token", VLAN: "1", MACs: []string{"111111111111"}},
+	})
+	conf := &core.Configuration{}
+	conf.Compose.Repository = repo
+	conf.DynAuth.Port = port
+	conf.DynAuth.Timeout = 1
+	conf.DynAuth.Request = request
+	if err := ConfigureDynAuth(&Context{secret: []byte("secret")}, conf); err != nil {
+		t.Errorf("unable to configure: %v", err)
+	}
+	return repo, received
+}
+
+func resetDynAuth() {
+	dynAuthLock.Lock()
+	defer dynAuthLock.Unlock()
+	dynAuth = nil
+}
+
+func TestDisconnect(t *testing.T) {
+	resetSessions("")
+	defer resetSessions("")
+	defer resetDynAuth()
+	if _, err := DisconnectCommand([]string{"aabbccddeeff"}); err == nil {
+		t.Error("not enabled")
+	}
+	_, received := configureDynAuth(t, core.DynAuthDisconnect)
+	addSession(Session{ID: "a", User: "AABBCCDDEEFF", CallingStation: "AA-BB-CC-DD-EE-FF", NASIP: "127.0.0.1", NASID: "nas"})
+	addSession(Session{ID: "b", User: "user.name:token", CallingStation: "11-22-33-44-55-66", NASIP: "127.0.0.1"})
+	for _, args := range [][]string{{}, {"xyz"}, {"665544332211"}} {
+		if _, err := DisconnectCommand(args); err == nil {
+			t.Errorf("invalid disconnect: %v", args)
+		}
+	}
+	res, err := DisconnectCommand([]string{"aa:bb:cc:dd:ee:ff"})
+	if err != nil || res != "disconnected AABBCCDDEEFF (AA-BB-CC-DD-EE-FF) on 127.0.0.1" {
+		t.Errorf("invalid disconnect: %s (%v)", res, err)
+	}
+	p := <-received
+	if p.Code != radius.CodeDisconnectRequest || rfc2866.AcctSessionID_GetString(p) != "a" || rfc2865.NASIdentifier_GetString(p) != "nas" || rfc2865.UserName_GetString(p) != "AABBCCDDEEFF" {
+		t.Error("invalid disconnect request")
+	}
+	addSession(Session{ID: "nak", User: "user.name:token", CallingStation: "665544332211", NASIP: "127.0.0.1"})
+	if _, err := Disconnect("665544332211"); err == nil || !strings.Contains(err.Error(), "Disconnect-NAK") {
+		t.Errorf("should nak: %v", err)
+	}
+	<-received
+	addSession(Session{ID: "noip", User: "user.name:token", CallingStation: "112233445566", NASIP: unknownNASIP})
+	disconnected, err := Disconnect("112233445566")
+	if err == nil || len(disconnected) != 1 || disconnected[0].ID != "b" {
+		t.Errorf("unknown nas should fail: %v", err)
+	}
+	<-received
+}
+
+func TestCheckAssignments(t *testing.T) {
+	resetSessions("")
+	defer resetSessions("")
+	defer resetDynAuth()
+	CheckAssignments()
+	repo, received := configureDynAuth(t, core.DynAuthCoA)
+	addSession(Session{ID: "mab", User: "aabbccddeeff", CallingStation: "aabbccddeeff", NASIP: "127.0.0.1"})
+	addSession(Session{ID: "mac", User: "user.name:token", CallingStation: "665544332211", NASIP: "127.0.0.1"})
+	addSession(Session{ID: "kept", User: "user.name:token", CallingStation: "112233445566", NASIP: "127.0.0.1"})
+	addSession(Session{ID: "vlan", User: "other.name:token", CallingStation: "111111111111", NASIP: "127.0.0.1"})
+	addSession(Session{ID: "new", User: "new.name:token", CallingStation: "222222222222", NASIP: "127.0.0.1"})
+	CheckAssignments()
+	if len(received) != 0 {
+		t.Error("nothing changed")
+	}
+	writeAssignments(t, repo, []compose.Assignment{
+		{Login: "user.name:token", VLAN: "1", MACs: []string{"112233445566"}},
+		{Login: "other.name:token", VLAN: "3", MACs: []string{"111111111111"}},
+	})
+	CheckAssignments()
+	requests := make(map[string]*radius.Packet)
+	for i := 0; i < 3; i++ {
+		p := <-received
+		requests[rfc2866.AcctSessionID_GetString(p)] = p
+	}
+	if len(received) != 0 {
+		t.Error("too many requests")
+	}
+	for _, id := range []string{"mab", "mac"} {
+		if p, ok := requests[id]; !ok || p.Code != radius.CodeDisconnectRequest {
+			t.Errorf("should disconnect: %s", id)
+		}
+	}
+	p, ok := requests["vlan"]
+	if !ok || p.Code != radius.CodeCoARequest {
+		t.Error("should change vlan")
+		return
+	}
+	if _, vlan := rfc2868.TunnelPrivateGroupID_GetString(p); vlan != "3" {
+		t.Error("should change vlan")
+	}
+	CheckAssignments()
+	if len(received) != 0 {
+		t.Error("already checked")
+	}
+	conf := &core.Configuration{}
+	conf.DynAuth.Request = "other"
+	if ConfigureDynAuth(&Context{}, conf) == nil {
+		t.Error("unknown request")
+	}
+}
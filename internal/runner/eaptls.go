@@ -0,0 +1,263 @@
+package runner
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const (
+	eapResponse = 2
+	eapFailure  = 4
+	eapTypeTLS  = 13
+	// EAP-TLS flags: the TLS message length is included
+	eapTLSLength = 0x80
+
+	tlsChangeCipherSpec = 20
+	tlsHandshake        = 22
+	tlsCertificate      = 11
+
+	// a peer's TLS data (a certificate chain) is expected to be much smaller
+	maxTLSData        = 64 * 1024
+	conversationLimit = 2 * time.Minute
+)
+
+type (
+	// tlsBindings binds certificate (EAP-TLS) logins to the client certificate, hostapd only
+	// verifies the certificate chains to its CA, the login must be the certificate's identity
+	tlsBindings struct {
+		lock    *sync.Mutex
+		states  map[string]*tlsConversation
+		pending map[byte]pendingRequest
+	}
+
+	// tlsConversation is the peer's side of an EAP conversation (followed by the State attribute)
+	tlsConversation struct {
+		user     string
+		tls      bool
+		data     []byte
+		finished bool
+		bound    bool
+		reason   string
+		seen     time.Time
+	}
+
+	pendingRequest struct {
+		conversation  *tlsConversation
+		authenticator [authenticatorLength]byte
+		secret        []byte
+	}
+)
+
+func newTLSBindings() *tlsBindings {
+	return &tlsBindings{lock: &sync.Mutex{}, states: make(map[string]*tlsConversation), pending: make(map[byte]pendingRequest)}
+}
+
+// request follows the EAP-TLS data a peer sends in an Access-Request
+func (b *tlsBindings) request(p *radius.Packet) {
+	if p.Code != radius.CodeAccessRequest {
+		return
+	}
+	user := core.GetUserFromLogin(rfc2865.UserName_GetString(p))
+	state := string(rfc2865.State_Get(p))
+	now := time.Now()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	conv, ok := b.states[state]
+	if state == "" || !ok || conv.user != user {
+		conv = &tlsConversation{user: user}
+		for k, v := range b.states {
+			if now.Sub(v.seen) > conversationLimit {
+				delete(b.states, k)
+			}
+		}
+	}
+	delete(b.states, state)
+	conv.seen = now
+	b.pending[p.Identifier] = pendingRequest{conversation: conv, authenticator: p.Authenticator, secret: p.Secret}
+	if data, ok := eapTLSData(rfc2869.EAPMessage_Get(p)); ok {
+		conv.tls = true
+		conv.add(data)
+	}
+}
+
+// response checks a backend response, an Access-Accept of an EAP-TLS conversation is only
+// allowed when the certificate is bound to the login (the rejection to send instead is returned)
+func (b *tlsBindings) response(resp []byte) ([]byte, error) {
+	if len(resp) < headerLength {
+		return nil, nil
+	}
+	b.lock.Lock()
+	req, ok := b.pending[resp[1]]
+	delete(b.pending, resp[1])
+	b.lock.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	conv := req.conversation
+	switch radius.Code(resp[0]) {
+	case radius.CodeAccessChallenge:
+		packet, err := radius.Parse(resp, nil)
+		if err != nil {
+			return nil, err
+		}
+		if state := rfc2865.State_Get(packet); len(state) > 0 {
+			b.lock.Lock()
+			b.states[string(state)] = conv
+			b.lock.Unlock()
+		}
+	case radius.CodeAccessAccept:
+		b.lock.Lock()
+		allowed := !conv.tls || conv.bound
+		reason := conv.reason
+		b.lock.Unlock()
+		if allowed {
+			return nil, nil
+		}
+		if reason == "" {
+			reason = "no client certificate seen"
+		}
+		core.WriteWarn(fmt.Sprintf("certificate is not bound to login: %s", conv.user), reason)
+		rej := radius.New(radius.CodeAccessReject, req.secret)
+		rej.Identifier = resp[1]
+		failure := []byte{eapFailure, 0, 0, 4}
+		if packet, err := radius.Parse(resp, nil); err == nil {
+			if success := rfc2869.EAPMessage_Get(packet); len(success) > 1 {
+				failure[1] = success[1]
+			}
+		}
+		if err := rfc2869.EAPMessage_Set(rej, failure); err != nil {
+			return nil, err
+		}
+		incMetric(responseMetric, "code", radius.CodeAccessReject.String(), "source", "tlsbinding")
+		return encodeResponse(rej, req.authenticator)
+	}
+	return nil, nil
+}
+
+// eapTLSData gets the TLS data of an EAP-TLS response
+func eapTLSData(eap []byte) ([]byte, bool) {
+	if len(eap) < 6 || eap[0] != eapResponse || eap[4] != eapTypeTLS {
+		return nil, false
+	}
+	length := int(eap[2])<<8 | int(eap[3])
+	if length > len(eap) || length < 6 {
+		return nil, false
+	}
+	flags := eap[5]
+	data := eap[6:length]
+	if flags&eapTLSLength != 0 {
+		if len(data) < 4 {
+			return nil, false
+		}
+		data = data[4:]
+	}
+	return data, true
+}
+
+// add collects the peer's TLS records until the certificate is found
+func (c *tlsConversation) add(data []byte) {
+	if c.finished {
+		return
+	}
+	if len(c.data)+len(data) > maxTLSData {
+		c.finished = true
+		c.reason = "too much tls data before a certificate"
+		return
+	}
+	c.data = append(c.data, data...)
+	cert, finished, err := peerCertificate(c.data)
+	if !finished {
+		return
+	}
+	c.finished = true
+	if err == nil {
+		err = certificateIdentity(cert, c.user)
+	}
+	if err != nil {
+		c.reason = err.Error()
+		return
+	}
+	c.bound = true
+}
+
+// peerCertificate finds the (leaf) certificate a peer sent in its handshake, the handshake
+// is only readable until the peer changes cipher spec
+func peerCertificate(data []byte) (*x509.Certificate, bool, error) {
+	var handshake []byte
+	changed := false
+	for len(data) >= 5 && !changed {
+		length := int(data[3])<<8 | int(data[4])
+		if len(data) < 5+length {
+			break
+		}
+		content := data[0]
+		record := data[5 : 5+length]
+		data = data[5+length:]
+		changed = content == tlsChangeCipherSpec
+		if content == tlsHandshake {
+			handshake = append(handshake, record...)
+		}
+	}
+	for len(handshake) >= 4 {
+		length := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		if len(handshake) < 4+length {
+			break
+		}
+		kind := handshake[0]
+		body := handshake[4 : 4+length]
+		handshake = handshake[4+length:]
+		if kind != tlsCertificate {
+			continue
+		}
+		if len(body) < 6 {
+			return nil, true, fmt.Errorf("no client certificate sent")
+		}
+		size := int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		if len(body) < 6+size {
+			return nil, true, fmt.Errorf("truncated client certificate")
+		}
+		cert, err := x509.ParseCertificate(body[6 : 6+size])
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		return cert, true, nil
+	}
+	if changed {
+		// TLS 1.3 encrypts the certificate (after the server hello), hostapd must disable it
+		return nil, true, fmt.Errorf("no readable client certificate before change cipher spec (TLS 1.3?)")
+	}
+	return nil, false, nil
+}
+
+// certificateIdentity checks a client certificate is for the user (the common name, an email, or a DNS name)
+func certificateIdentity(cert *x509.Certificate, user string) error {
+	if user == "" {
+		return fmt.Errorf("no user to bind")
+	}
+	client := len(cert.ExtKeyUsage) == 0
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageClientAuth || usage == x509.ExtKeyUsageAny {
+			client = true
+		}
+	}
+	if !client {
+		return fmt.Errorf("certificate does not allow client authentication: %s", cert.Subject.CommonName)
+	}
+	if cert.Subject.CommonName == user {
+		return nil
+	}
+	for _, name := range append(cert.EmailAddresses, cert.DNSNames...) {
+		if strings.EqualFold(name, user) {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate is for another identity: %s", cert.Subject.CommonName)
+}
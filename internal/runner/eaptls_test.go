@@ -0,0 +1,186 @@
+package runner
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+)
+
+func newCertificate(t *testing.T, name string, usage x509.ExtKeyUsage) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("unable to generate key")
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("unable to create certificate")
+	}
+	return der
+}
+
+// peerHandshake is the TLS data a peer sends with its certificate (followed by change cipher spec)
+func peerHandshake(der []byte) []byte {
+	size := func(n int) []byte {
+		return []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	body := append(size(len(der)+3), size(len(der))...)
+	body = append(body, der...)
+	message := append([]byte{tlsCertificate}, size(len(body))...)
+	message = append(message, body...)
+	record := []byte{tlsHandshake, 3, 3, byte(len(message) >> 8), byte(len(message))}
+	record = append(record, message...)
+	return append(record, tlsChangeCipherSpec, 3, 3, 0, 1, 1)
+}
+
+func eapTLSResponse(id byte, flags byte, data []byte) []byte {
+	eap := []byte{eapResponse, id, 0, 0, eapTypeTLS, flags}
+	if flags&eapTLSLength != 0 {
+		eap = append(eap, 0, 0, byte(len(data)>>8), byte(len(data)))
+	}
+	eap = append(eap, data...)
+	eap[2] = byte(len(eap) >> 8)
+	eap[3] = byte(len(eap))
+	return eap
+}
+
+func tlsRequest(t *testing.T, r *Relay, id byte, user string, state, eap []byte) {
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Identifier = id
+	if err := rfc2865.UserName_SetString(p, user); err != nil {
+		t.Error("unable to set user")
+	}
+	if state != nil {
+		if err := rfc2865.State_Set(p, state); err != nil {
+			t.Error("unable to set state")
+		}
+	}
+	if err := rfc2869.EAPMessage_Set(p, eap); err != nil {
+		t.Error("unable to set eap")
+	}
+	b, err := encodeResponse(p, p.Authenticator)
+	if err != nil {
+		t.Error("unable to encode")
+	}
+	packet := NewClientPacket(b, nil)
+	packet.Packet, packet.Error = radius.Parse(b, []byte("secret"))
+	if _, err := r.Request(packet); err != nil {
+		t.Errorf("unable to relay: %v", err)
+	}
+}
+
+func tlsResponse(t *testing.T, r *Relay, code radius.Code, id byte, state []byte) *radius.Packet {
+	p := radius.New(code, []byte("secret"))
+	p.Identifier = id
+	if state != nil {
+		if err := rfc2865.State_Set(p, state); err != nil {
+			t.Error("unable to set state")
+		}
+	}
+	if code == radius.CodeAccessAccept {
+		if err := rfc2869.EAPMessage_Set(p, []byte{3, 9, 0, 4}); err != nil {
+			t.Error("unable to set eap")
+		}
+	}
+	b, err := p.Encode()
+	if err != nil {
+		t.Error("unable to encode")
+	}
+	b, err = r.Response(b)
+	if err != nil {
+		t.Errorf("unable to relay: %v", err)
+	}
+	result, err := radius.Parse(b, []byte("secret"))
+	if err != nil {
+		t.Error("unable to parse")
+	}
+	return result
+}
+
+// conversation runs an EAP-TLS conversation where the peer sends a certificate (in two fragments)
+func conversation(t *testing.T, user string, der []byte) *radius.Packet {
+	r := (&Context{secret: []byte("secret")}).NewRelay()
+	tlsRequest(t, r, 1, user, nil, []byte{eapResponse, 1, 0, 5, 1})
+	tlsResponse(t, r, radius.CodeAccessChallenge, 1, []byte("state1"))
+	data := peerHandshake(der)
+	half := len(data) / 2
+	tlsRequest(t, r, 2, user, []byte("state1"), eapTLSResponse(2, eapTLSLength|0x40, data[:half]))
+	tlsResponse(t, r, radius.CodeAccessChallenge, 2, []byte("state2"))
+	tlsRequest(t, r, 3, user, []byte("state2"), eapTLSResponse(3, 0, data[half:]))
+	return tlsResponse(t, r, radius.CodeAccessAccept, 3, nil)
+}
+
+func TestTLSBinding(t *testing.T) {
+	cert := newCertificate(t, "cert.name", x509.ExtKeyUsageClientAuth)
+	if p := conversation(t, "cert.name", cert); p.Code != radius.CodeAccessAccept {
+		t.Error("certificate is for the login")
+	}
+	if p := conversation(t, "cert.name@vlan.abc", cert); p.Code != radius.CodeAccessAccept {
+		t.Error("certificate is for the user")
+	}
+	p := conversation(t, "other.name", cert)
+	if p.Code != radius.CodeAccessReject {
+		t.Error("certificate is not for the login")
+	}
+	if eap := rfc2869.EAPMessage_Get(p); len(eap) != 4 || eap[0] != eapFailure || eap[1] != 9 {
+		t.Error("invalid eap failure")
+	}
+	if p := conversation(t, "cert.name", newCertificate(t, "cert.name", x509.ExtKeyUsageServerAuth)); p.Code != radius.CodeAccessReject {
+		t.Error("not a client certificate")
+	}
+	if p := conversation(t, "cert.name", nil); p.Code != radius.CodeAccessReject {
+		t.Error("no certificate")
+	}
+}
+
+func TestTLSBindingOtherMethods(t *testing.T) {
+	r := (&Context{secret: []byte("secret")}).NewRelay()
+	peap := []byte{eapResponse, 2, 0, 6, 25, 0}
+	tlsRequest(t, r, 1, "user.name:token", nil, peap)
+	if p := tlsResponse(t, r, radius.CodeAccessAccept, 1, nil); p.Code != radius.CodeAccessAccept {
+		t.Error("not an EAP-TLS conversation")
+	}
+	if p := tlsResponse(t, r, radius.CodeAccessAccept, 7, nil); p.Code != radius.CodeAccessAccept {
+		t.Error("unknown requests are relayed")
+	}
+}
+
+func TestTLSBindingReasons(t *testing.T) {
+	// TLS 1.3: only encrypted application data follows change cipher spec
+	c := &tlsConversation{user: "cert.name"}
+	c.add([]byte{tlsChangeCipherSpec, 3, 3, 0, 1, 1, 23, 3, 3, 0, 1, 0})
+	if !c.finished || c.bound || !strings.Contains(c.reason, "TLS 1.3") {
+		t.Errorf("invalid reason: %s", c.reason)
+	}
+	c = &tlsConversation{user: "other.name"}
+	c.add(peerHandshake(newCertificate(t, "cert.name", x509.ExtKeyUsageClientAuth)))
+	if c.bound || !strings.Contains(c.reason, "another identity") {
+		t.Errorf("invalid reason: %s", c.reason)
+	}
+	c = &tlsConversation{user: "cert.name"}
+	c.add(peerHandshake(newCertificate(t, "cert.name", x509.ExtKeyUsageServerAuth)))
+	if c.bound || !strings.Contains(c.reason, "client authentication") {
+		t.Errorf("invalid reason: %s", c.reason)
+	}
+	c = &tlsConversation{user: "cert.name"}
+	c.add(make([]byte, maxTLSData+1))
+	if !c.finished || c.bound || c.reason == "" {
+		t.Error("too much data")
+	}
+}
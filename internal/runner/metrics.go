@@ -0,0 +1,212 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+const (
+	counterMetric   = "counter"
+	gaugeMetric     = "gauge"
+	histogramMetric = "histogram"
+
+	preAuthMetric    = "dotonex_preauth_total"
+	responseMetric   = "dotonex_access_responses_total"
+	accountingMetric = "dotonex_accounting_packets_total"
+	retransmitMetric = "dotonex_accounting_retransmits_total"
+	scriptMetric     = "dotonex_script_duration_seconds"
+	dynAuthMetric    = "dotonex_dynauth_requests_total"
+)
+
+var (
+	metricLock = new(sync.Mutex)
+	metrics    = make(map[string]*metric)
+	// script latency buckets (seconds)
+	scriptBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	// label values escape backslashes, quotes, and newlines (text exposition format)
+	labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+)
+
+type (
+	metric struct {
+		help   string
+		kind   string
+		values map[string]float64
+		hists  map[string]*histogram
+		gauge  func() float64
+	}
+
+	histogram struct {
+		counts []uint64
+		sum    float64
+		count  uint64
+	}
+)
+
+func init() {
+	defineMetric(preAuthMetric, counterMetric, "pre-authorization decisions by result and reason")
+	defineMetric(responseMetric, counterMetric, "access responses sent to clients by code and source")
+	defineMetric(accountingMetric, counterMetric, "accounting packets received by status type")
+	defineMetric(retransmitMetric, counterMetric, "accounting retransmits answered without being recorded")
+	defineMetric(dynAuthMetric, counterMetric, "dynamic authorization requests sent to a NAS by code and result")
+	defineMetric(scriptMetric, histogramMetric, "backend script execution time by mode")
+	RegisterGauge("dotonex_log_buffer_entries", "buffered plugin log entries waiting to be written", func() float64 {
+		pluginLock.Lock()
+		defer pluginLock.Unlock()
+		return float64(len(pluginLogs))
+	})
+}
+
+func defineMetric(name, kind, help string) *metric {
+	metricLock.Lock()
+	defer metricLock.Unlock()
+	m := &metric{help: help, kind: kind, values: make(map[string]float64), hists: make(map[string]*histogram)}
+	metrics[name] = m
+	return m
+}
+
+// RegisterGauge exposes a value that is read when metrics are written
+func RegisterGauge(name, help string, gauge func() float64) {
+	m := defineMetric(name, gaugeMetric, help)
+	m.gauge = gauge
+}
+
+func labels(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", pairs[i], labelEscaper.Replace(pairs[i+1])))
+	}
+	return strings.Join(parts, ",")
+}
+
+func incMetric(name string, pairs ...string) {
+	metricLock.Lock()
+	defer metricLock.Unlock()
+	m, ok := metrics[name]
+	if !ok {
+		return
+	}
+	m.values[labels(pairs...)]++
+}
+
+func observeMetric(name string, value float64, pairs ...string) {
+	metricLock.Lock()
+	defer metricLock.Unlock()
+	m, ok := metrics[name]
+	if !ok {
+		return
+	}
+	key := labels(pairs...)
+	h, ok := m.hists[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(scriptBuckets))}
+		m.hists[key] = h
+	}
+	for i, b := range scriptBuckets {
+		if value <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func observeScript(mode string, started time.Time) {
+	observeMetric(scriptMetric, time.Since(started).Seconds(), "mode", mode)
+}
+
+func series(name, label, extra string) string {
+	all := label
+	if extra != "" {
+		if all != "" {
+			all = all + ","
+		}
+		all = all + extra
+	}
+	if all == "" {
+		return name
+	}
+	return fmt.Sprintf("%s{%s}", name, all)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteMetrics writes all metrics in the prometheus text format
+func WriteMetrics(w io.Writer) {
+	// the output is buffered, a slow reader does not hold the metric lock
+	var buffer bytes.Buffer
+	metricLock.Lock()
+	var names []string
+	for name := range metrics {
+		names = append(names, name)
+	}
+	metricLock.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		metricLock.Lock()
+		m := metrics[name]
+		metricLock.Unlock()
+		var value float64
+		if m.gauge != nil {
+			// gauges may take other locks, do not hold the metric lock
+			value = m.gauge()
+		}
+		metricLock.Lock()
+		fmt.Fprintf(&buffer, "# HELP %s %s\n# TYPE %s %s\n", name, m.help, name, m.kind)
+		switch m.kind {
+		case gaugeMetric:
+			fmt.Fprintf(&buffer, "%s %v\n", name, value)
+		case counterMetric:
+			for _, k := range sortedKeys(m.values) {
+				fmt.Fprintf(&buffer, "%s %v\n", series(name, k, ""), m.values[k])
+			}
+		case histogramMetric:
+			var keys []string
+			for k := range m.hists {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				h := m.hists[k]
+				for i, b := range scriptBuckets {
+					fmt.Fprintf(&buffer, "%s %d\n", series(name+"_bucket", k, fmt.Sprintf("le=\"%v\"", b)), h.counts[i])
+				}
+				fmt.Fprintf(&buffer, "%s %d\n", series(name+"_bucket", k, "le=\"+Inf\""), h.count)
+				fmt.Fprintf(&buffer, "%s %v\n", series(name+"_sum", k, ""), h.sum)
+				fmt.Fprintf(&buffer, "%s %d\n", series(name+"_count", k, ""), h.count)
+			}
+		}
+		metricLock.Unlock()
+	}
+	if _, err := buffer.WriteTo(w); err != nil {
+		core.WriteError("unable to write metrics", err)
+	}
+}
+
+// ServeMetrics runs the metrics http listener
+func ServeMetrics(bind string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w)
+	})
+	core.WriteInfo(fmt.Sprintf("metrics listening: %s", bind))
+	if err := http.ListenAndServe(bind, mux); err != nil {
+		core.WriteError("metrics listener", err)
+	}
+}
@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockedWriter does not complete writes until released
+type blockedWriter struct {
+	writing chan bool
+	release chan bool
+}
+
+func (w blockedWriter) Write(p []byte) (int, error) {
+	select {
+	case w.writing <- true:
+	default:
+	}
+	<-w.release
+	return len(p), nil
+}
+
+func TestWriteMetrics(t *testing.T) {
+	incMetric(preAuthMetric, "result", "FAILED", "reason", "NOMACFOUND")
+	incMetric(preAuthMetric, "result", "FAILED", "reason", "NOMACFOUND")
+	incMetric(preAuthMetric, "result", "PASSED")
+	observeMetric(scriptMetric, 0.2, "mode", "validate")
+	observeMetric(scriptMetric, 3, "mode", "validate")
+	RegisterGauge("dotonex_test_gauge", "test", func() float64 {
+		return 5
+	})
+	var b bytes.Buffer
+	WriteMetrics(&b)
+	out := b.String()
+	for _, expect := range []string{
+		"# TYPE dotonex_preauth_total counter\n",
+		"dotonex_preauth_total{result=\"FAILED\",reason=\"NOMACFOUND\"} 2\n",
+		"dotonex_preauth_total{result=\"PASSED\"} 1\n",
+		"dotonex_script_duration_seconds_bucket{mode=\"validate\",le=\"0.1\"} 0\n",
+		"dotonex_script_duration_seconds_bucket{mode=\"validate\",le=\"0.25\"} 1\n",
+		"dotonex_script_duration_seconds_bucket{mode=\"validate\",le=\"+Inf\"} 2\n",
+		"dotonex_script_duration_seconds_sum{mode=\"validate\"} 3.2\n",
+		"dotonex_script_duration_seconds_count{mode=\"validate\"} 2\n",
+		"dotonex_log_buffer_entries ",
+		"dotonex_test_gauge 5\n",
+	} {
+		if !strings.Contains(out, expect) {
+			t.Errorf("missing metric: %s", expect)
+		}
+	}
+}
+
+func TestSlowMetricsReader(t *testing.T) {
+	w := blockedWriter{writing: make(chan bool, 1), release: make(chan bool)}
+	done := make(chan bool)
+	go func() {
+		WriteMetrics(w)
+		done <- true
+	}()
+	<-w.writing
+	counted := make(chan bool)
+	go func() {
+		incMetric(preAuthMetric, "result", "PASSED")
+		counted <- true
+	}()
+	select {
+	case <-counted:
+	case <-time.After(time.Second):
+		t.Error("metrics blocked by a slow reader")
+	}
+	close(w.release)
+	<-done
+}
+
+func TestMetricLabels(t *testing.T) {
+	if labels() != "" {
+		t.Error("no labels")
+	}
+	if labels("a", "b", "c", "d\"") != "a=\"b\",c=\"d\\\"\"" {
+		t.Error("invalid labels")
+	}
+	if l := labels("nas", "a\\b\nc\"d"); l != `nas="a\\b\nc\"d"` {
+		t.Errorf("invalid escaping: %s", l)
+	}
+	if series("name", "", "") != "name" || series("name", "a=\"b\"", "") != "name{a=\"b\"}" {
+		t.Error("invalid series")
+	}
+}
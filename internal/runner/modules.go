@@ -2,6 +2,9 @@ package runner
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -11,12 +14,21 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
+	"layeh.com/radius"
 	"layeh.com/radius/debug"
+	"layeh.com/radius/dictionary"
 	"layeh.com/radius/rfc2865"
 	"voidedtech.com/dotonex/internal/core"
 )
 
+const (
+	// placeholders when a packet does not identify the NAS
+	unknownNAS   = "unknown"
+	unknownNASIP = "noip"
+)
+
 var (
 	pluginLock = new(sync.Mutex)
 	pluginLogs = []string{}
@@ -28,21 +40,33 @@ type (
 	requestDump struct {
 		data *ClientPacket
 		mode string
+		info keyValue
 	}
 
 	// KeyValue represents a simple key/value object
 	keyValue struct {
 		key   string
-		value string
+		value interface{}
 	}
 
 	keyValueStore struct {
 		keyValues []keyValue
 	}
+
+	// pluginMessage is a plugin log record as text lines or typed fields
+	pluginMessage interface {
+		strings() []string
+		fields() []keyValue
+	}
+
+	packetAttribute struct {
+		Name  string      `json:"name"`
+		Value interface{} `json:"value"`
+	}
 )
 
-func newRequestDump(packet *ClientPacket, mode string) *requestDump {
-	return &requestDump{data: packet, mode: mode}
+func newRequestDump(packet *ClientPacket, mode string, info keyValue) *requestDump {
+	return &requestDump{data: packet, mode: mode, info: info}
 }
 
 func internalError(op string, err error) {
@@ -72,6 +96,65 @@ func (packet *requestDump) dumpPacket(kv keyValue) []string {
 	return results
 }
 
+func (packet *requestDump) strings() []string {
+	return packet.dumpPacket(packet.info)
+}
+
+func (packet *requestDump) fields() []keyValue {
+	kv := keyValueStore{}
+	kv.keyValues = append(kv.keyValues, packet.info)
+	kv.add("Mode", packet.mode)
+	if packet.data.ClientAddr != nil {
+		kv.add("UDPAddr", packet.data.ClientAddr.String())
+	}
+	p := packet.data.Packet
+	kv.add("Code", p.Code.String())
+	kv.add("Id", int(p.Identifier))
+	attrs := []packetAttribute{}
+	for _, avp := range p.Attributes {
+		attrs = append(attrs, newPacketAttribute(avp))
+	}
+	kv.add("Attributes", attrs)
+	return kv.keyValues
+}
+
+func newPacketAttribute(avp *radius.AVP) packetAttribute {
+	dict := debug.IncludedDictionary
+	attr := dictionary.AttributeByOID(dict.Attributes, dictionary.OID{int(avp.Type)})
+	hexed := "0x" + hex.EncodeToString(avp.Attribute)
+	if attr == nil {
+		return packetAttribute{Name: "#" + strconv.Itoa(int(avp.Type)), Value: hexed}
+	}
+	result := packetAttribute{Name: attr.Name, Value: hexed}
+	switch attr.Type {
+	case dictionary.AttributeString:
+		// encrypted values (e.g. passwords) are never logged in the clear
+		if !attr.FlagEncrypt.Valid && utf8.Valid(avp.Attribute) {
+			result.Value = string(avp.Attribute)
+		}
+	case dictionary.AttributeInteger:
+		if len(avp.Attribute) == 4 {
+			number := uint64(binary.BigEndian.Uint32(avp.Attribute))
+			result.Value = number
+			for _, v := range dictionary.ValuesByAttribute(dict.Values, attr.Name) {
+				if v.Number == number {
+					result.Value = v.Name
+					break
+				}
+			}
+		}
+	case dictionary.AttributeDate:
+		if len(avp.Attribute) == 4 {
+			result.Value = time.Unix(int64(binary.BigEndian.Uint32(avp.Attribute)), 0).UTC().Format(time.RFC3339)
+		}
+	case dictionary.AttributeIPAddr, dictionary.AttributeIPv6Addr:
+		if len(avp.Attribute) == net.IPv4len || len(avp.Attribute) == net.IPv6len {
+			result.Value = net.IP(avp.Attribute).String()
+		}
+	}
+	return result
+}
+
 func newFile(path, instance string) *os.File {
 	t := time.Now()
 	inst := instance
@@ -109,32 +192,62 @@ func WritePluginMessages(path, instance string) {
 	pluginLID = 0
 }
 
-func logPluginMessages(module string, messages []string) {
+func logPluginMessages(module string, message pluginMessage) {
 	pluginLock.Lock()
 	defer pluginLock.Unlock()
 	name := strings.ToUpper(module)
-	t := time.Now().Format("2006-01-02T15:04:05.000")
+	t := core.LogTime(time.Now())
 	idx := pluginLID
-	for _, m := range messages {
-		pluginLogs = append(pluginLogs, fmt.Sprintf("%s [%s] (%d) %s\n", t, name, idx, m))
+	if core.JSONLogs() {
+		pluginLogs = append(pluginLogs, jsonMessage(t, module, idx, message.fields()))
+	} else {
+		for _, m := range message.strings() {
+			pluginLogs = append(pluginLogs, fmt.Sprintf("%s [%s] (%d) %s\n", t, name, idx, m))
+		}
 	}
 	pluginLID++
 }
 
+func jsonMessage(t, module string, idx int, fields []keyValue) string {
+	all := []keyValue{{key: "time", value: t}, {key: "module", value: module}, {key: "idx", value: idx}}
+	all = append(all, fields...)
+	var w bytes.Buffer
+	w.WriteString("{")
+	for i, kv := range all {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		key, err := json.Marshal(kv.key)
+		if err != nil {
+			internalError("json key", err)
+			continue
+		}
+		value, err := json.Marshal(kv.value)
+		if err != nil {
+			internalError("json value", err)
+			value = []byte("null")
+		}
+		w.Write(key)
+		w.WriteString(":")
+		w.Write(value)
+	}
+	w.WriteString("}\n")
+	return w.String()
+}
+
 func (kv keyValue) str() string {
-	return fmt.Sprintf("%s = %s", kv.key, kv.value)
+	return fmt.Sprintf("%s = %v", kv.key, kv.value)
 }
 
 func moduleWrite(mode string, objType TraceType, packet *ClientPacket) {
 	go func() {
-		dump := newRequestDump(packet, mode)
-		messages := dump.dumpPacket(keyValue{key: "Info", value: fmt.Sprintf("%d", int(objType))})
-		logPluginMessages(mode, messages)
+		logPluginMessages(mode, newRequestDump(packet, mode, keyValue{key: "Info", value: int(objType)}))
 	}()
 }
 
 // AccountPacket will do accounting operations
 func AccountPacket(packet *ClientPacket) {
+	trackSession(packet)
 	moduleWrite("accounting", NoTrace, packet)
 }
 
@@ -165,11 +278,14 @@ func checkUserMac(p *ClientPacket) error {
 	calling = clean(calling)
 	var failure error
 	reason := ""
+	cached := false
 	cleaned, isMAC := core.CleanMAC(calling)
 	if isMAC {
 		if calling == clean(userName) {
 			// MAC is valid within overall configuration
-			if !CheckMAC(cleaned) {
+			valid, hit := checkMAC(cleaned)
+			cached = hit
+			if !valid {
 				reason = "NOMACFOUND"
 			}
 		} else {
@@ -177,9 +293,19 @@ func checkUserMac(p *ClientPacket) error {
 			tokenUser, token := core.GetTokenFromLogin(userName)
 			if token == "" || tokenUser == "" {
 				reason = "INVALIDTOKEN"
+				if tlsUser := core.GetUserFromLogin(userName); tlsUser != "" && tlsLogins() {
+					reason = "TLSMACFAIL"
+					valid, hit := checkTLSMAC(tlsUser, cleaned)
+					cached = hit
+					if valid {
+						reason = ""
+					}
+				}
 			} else {
 				reason = "TOKENMACFAIL"
-				if CheckTokenMAC(tokenUser, token, cleaned) {
+				valid, hit := checkTokenMAC(tokenUser, token, cleaned)
+				cached = hit
+				if valid {
 					reason = ""
 				}
 			}
@@ -190,17 +316,17 @@ func checkUserMac(p *ClientPacket) error {
 	if reason != "" {
 		failure = fmt.Errorf("failed preauth: %s %s (%s)", userName, calling, reason)
 	}
-	go mark(reason, userName, calling, p, false)
+	go mark(reason, userName, calling, p, cached)
 	return failure
 }
 
-func mark(reason, user, calling string, p *ClientPacket, cached bool) {
+func nasInfo(p *ClientPacket) (string, string) {
 	nas := clean(rfc2865.NASIdentifier_GetString(p.Packet))
 	if len(nas) == 0 {
-		nas = "unknown"
+		nas = unknownNAS
 	}
 	nasipraw := rfc2865.NASIPAddress_Get(p.Packet)
-	nasip := "noip"
+	nasip := unknownNASIP
 	if nasipraw == nil {
 		if p.ClientAddr != nil {
 			h, _, err := net.SplitHostPort(p.ClientAddr.String())
@@ -211,6 +337,11 @@ func mark(reason, user, calling string, p *ClientPacket, cached bool) {
 	} else {
 		nasip = nasipraw.String()
 	}
+	return nas, nasip
+}
+
+func mark(reason, user, calling string, p *ClientPacket, cached bool) {
+	nas, nasip := nasInfo(p)
 	nasport := rfc2865.NASPort_Get(p.Packet)
 	result := "PASSED"
 	showReason := reason != ""
@@ -221,20 +352,30 @@ func mark(reason, user, calling string, p *ClientPacket, cached bool) {
 	kv.add("Result", result)
 	if showReason {
 		kv.add("Reason", reason)
+		incMetric(preAuthMetric, "result", result, "reason", reason)
+	} else {
+		incMetric(preAuthMetric, "result", result)
 	}
 	kv.add("User-Name", user)
 	kv.add("Calling-Station-Id", calling)
 	kv.add("NAS-Id", nas)
 	kv.add("NAS-IPAddress", nasip)
-	kv.add("NAS-Port", fmt.Sprintf("%d", nasport))
-	kv.add("Id", strconv.Itoa(int(p.Packet.Identifier)))
-	logPluginMessages("proxy", kv.strings())
+	kv.add("NAS-Port", uint32(nasport))
+	kv.add("Id", int(p.Packet.Identifier))
+	if cached {
+		kv.add("Cached", cached)
+	}
+	logPluginMessages("proxy", kv)
 }
 
-func (kv *keyValueStore) add(key, val string) {
+func (kv *keyValueStore) add(key string, val interface{}) {
 	kv.keyValues = append(kv.keyValues, keyValue{key: key, value: val})
 }
 
+func (kv keyValueStore) fields() []keyValue {
+	return kv.keyValues
+}
+
 func (kv keyValueStore) strings() []string {
 	var objs []string
 	offset := ""
@@ -3,9 +3,11 @@ package runner
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"layeh.com/radius"
 	"layeh.com/radius/rfc2865"
+	"voidedtech.com/dotonex/internal/core"
 )
 
 func TestKeyValueString(t *testing.T) {
@@ -35,6 +37,46 @@ func TestKeyValueStrings(t *testing.T) {
 	}
 }
 
+func TestJSONMessage(t *testing.T) {
+	c := keyValueStore{}
+	c.add("Result", "PASSED")
+	c.add("NAS-Port", uint32(1))
+	c.add("Id", 2)
+	res := jsonMessage("time", "proxy", 3, c.fields())
+	if res != `{"time":"time","module":"proxy","idx":3,"Result":"PASSED","NAS-Port":1,"Id":2}`+"\n" {
+		t.Errorf("invalid json: %s", res)
+	}
+}
+
+func TestRequestDumpFields(t *testing.T) {
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Identifier = 5
+	rfc2865.UserName_SetString(p, "user")
+	rfc2865.UserPassword_SetString(p, "password12345678")
+	rfc2865.NASPort_Set(p, 10)
+	dump := newRequestDump(&ClientPacket{Packet: p}, "trace", keyValue{key: "Info", value: 1})
+	fields := dump.fields()
+	if len(fields) != 5 {
+		t.Error("invalid fields")
+	}
+	if fields[0].key != "Info" || fields[1].value != "trace" || fields[2].value != "Access-Request" || fields[3].value != 5 {
+		t.Error("invalid packet fields")
+	}
+	attrs := fields[4].value.([]packetAttribute)
+	if len(attrs) != 3 {
+		t.Error("invalid attributes")
+	}
+	if attrs[0].Name != "User-Name" || attrs[0].Value != "user" {
+		t.Error("invalid user")
+	}
+	if attrs[1].Name != "User-Password" || attrs[1].Value == "password12345678" {
+		t.Error("password should not be logged")
+	}
+	if attrs[2].Name != "NAS-Port" || attrs[2].Value != uint64(10) {
+		t.Error("invalid port")
+	}
+}
+
 func TestUserMacBasics(t *testing.T) {
 	newTestSet(t, "user:test", "11-22-33-44-55-66", true, "")
 	newTestSet(t, "user:test@vlan.test", "11-22-33-44-55-66", true, "")
@@ -43,6 +85,25 @@ func TestUserMacBasics(t *testing.T) {
 	newTestSet(t, "11-22-33-11-22-33", "11-22-33-11-22-33", false, "NOMACFOUND")
 }
 
+func TestUserMacTLS(t *testing.T) {
+	p := newTestSet(t, "user.name@vlan.test", "11-22-33-44-55-66", false, "INVALIDTOKEN")
+	cfg := core.Composition{TLS: true, Binary: "true"}
+	callLock.Lock()
+	backend = &script{cfg: cfg, composer: execComposer{cfg: cfg, timeout: time.Second}}
+	callLock.Unlock()
+	ErrorIfNotPre(t, p, "")
+	cfg.Binary = "false"
+	callLock.Lock()
+	backend = &script{cfg: cfg, composer: execComposer{cfg: cfg, timeout: time.Second}}
+	callLock.Unlock()
+	ErrorIfNotPre(t, p, "failed preauth: user.name@vlan.test 112233445566 (TLSMACFAIL)")
+	cfg.TLS = false
+	callLock.Lock()
+	backend = &script{cfg: cfg, composer: execComposer{cfg: cfg, timeout: time.Second}}
+	callLock.Unlock()
+	ErrorIfNotPre(t, p, "failed preauth: user.name@vlan.test 112233445566 (INVALIDTOKEN)")
+}
+
 func ErrorIfNotPre(t *testing.T, p *ClientPacket, message string) {
 	err := checkUserMac(p)
 	if err == nil {
@@ -84,4 +145,20 @@ func TestUserMacCache(t *testing.T) {
 	first := "failed preauth: user:test 112233445568 (TOKENMACFAIL)"
 	ErrorIfNotPre(t, pg, "")
 	ErrorIfNotPre(t, pb, first)
+	decisions.configure(time.Minute, time.Minute)
+	defer decisions.configure(0, 0)
+	ErrorIfNotPre(t, pg, "")
+	ErrorIfNotPre(t, pb, first)
+	// backend changes are not seen until the cache is cleared
+	callLock.Lock()
+	backend = &script{payload: []string{"test/112233445568"}, static: true}
+	callLock.Unlock()
+	ErrorIfNotPre(t, pg, "")
+	ErrorIfNotPre(t, pb, first)
+	if valid, cached := checkTokenMAC("user", "test", "112233445566"); !valid || !cached {
+		t.Error("should be cached")
+	}
+	decisions.clear()
+	ErrorIfNotPre(t, pg, "failed preauth: user:test 112233445566 (TOKENMACFAIL)")
+	ErrorIfNotPre(t, pb, "")
 }
@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"layeh.com/radius"
+)
+
+const (
+	// RadSecSecret is the fixed shared secret used within RadSec (RFC 6614)
+	RadSecSecret = "radsec"
+)
+
+// NewRadSecConfig creates a (mutual) TLS configuration for a RadSec listener
+func NewRadSecConfig(cert, key, ca string) (*tls.Config, error) {
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(ca)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in CA: %s", ca)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ReadFrame reads a single RADIUS packet from a stream
+func ReadFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < headerLength || length > radius.MaxPacketLength {
+		return nil, fmt.Errorf("invalid frame length: %d", length)
+	}
+	b := make([]byte, length)
+	copy(b, header)
+	if _, err := io.ReadFull(r, b[4:]); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// HandleRadSec handles pre-authorization checks for a packet received over RadSec
+func HandleRadSec(ctx *Context, b []byte, addr net.Addr, write writeBack) (*ClientPacket, bool) {
+	packet := NewClientPacket(b, nil)
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		packet.ClientAddr = &net.UDPAddr{IP: tcp.IP, Port: tcp.Port, Zone: tcp.Zone}
+	}
+	packet.RadSec = true
+	return handlePreAuth(ctx, packet, write)
+}
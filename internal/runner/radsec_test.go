@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func TestReadFrame(t *testing.T) {
+	p := radius.New(radius.CodeAccessRequest, []byte(RadSecSecret))
+	if err := rfc2865.UserName_AddString(p, "user"); err != nil {
+		t.Error("unable to add user name")
+	}
+	b, err := p.Encode()
+	if err != nil {
+		t.Error("unable to encode")
+	}
+	stream := bytes.NewBuffer(append(append([]byte{}, b...), b...))
+	for i := 0; i < 2; i++ {
+		frame, err := ReadFrame(stream)
+		if err != nil || !bytes.Equal(frame, b) {
+			t.Error("invalid frame")
+		}
+	}
+	if _, err := ReadFrame(stream); err == nil {
+		t.Error("no more frames")
+	}
+	if _, err := ReadFrame(bytes.NewBuffer([]byte{1, 1, 0, 5, 0})); err == nil {
+		t.Error("invalid frame length")
+	}
+	if _, err := ReadFrame(bytes.NewBuffer(b[0:10])); err == nil {
+		t.Error("short frame")
+	}
+}
+
+func TestHandleRadSec(t *testing.T) {
+	ctx, _ := getPacket(t)
+	c, err := newClient(core.Client{Address: "10.0.0.1", Secret: "secret"})
+	if err != nil {
+		t.Error("invalid client")
+	}
+	ctx.clients = append(ctx.clients, c)
+	p := radius.New(radius.CodeAccessRequest, []byte(RadSecSecret))
+	b, err := p.Encode()
+	if err != nil {
+		t.Error("unable to encode")
+	}
+	packet, ok := HandleRadSec(ctx, b, &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 2083}, nil)
+	if !ok {
+		t.Error("radsec clients use the radsec secret")
+	}
+	if string(packet.Packet.Secret) != RadSecSecret {
+		t.Error("invalid secret")
+	}
+	if packet.ClientAddr.String() != "192.168.1.1:2083" {
+		t.Error("invalid address")
+	}
+}
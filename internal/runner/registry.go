@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// Continue passes the pre-auth decision to the next module (rejected when none decide)
+	Continue Decision = 0
+	// Accept ends the pre-auth chain, the packet is passed on
+	Accept Decision = 1
+	// Reject ends the pre-auth chain, the packet is rejected
+	Reject Decision = 2
+	// UserMACModule is the default user+mac pre-auth module
+	UserMACModule = "usermac"
+	// TraceModule is the default packet tracing module
+	TraceModule = "trace"
+	// AccountingModule is the default accounting module
+	AccountingModule = "accounting"
+)
+
+var (
+	registryLock = new(sync.Mutex)
+	preModules   = make(map[string]PreAuthModule)
+	traceModules = make(map[string]Trace)
+	acctModules  = make(map[string]Account)
+)
+
+type (
+	// Decision is the result of a pre-auth module
+	Decision int
+
+	// PreAuthModule is a named pre-auth step within a chain
+	PreAuthModule func(*ClientPacket) Decision
+)
+
+func init() {
+	RegisterPreAuth(UserMACModule, func(p *ClientPacket) Decision {
+		if PrePacket(p) {
+			return Accept
+		}
+		return Reject
+	})
+	RegisterTrace(TraceModule, TracePacket)
+	RegisterAccount(AccountingModule, AccountPacket)
+}
+
+// RegisterPreAuth makes a pre-auth module available by name
+func RegisterPreAuth(name string, module PreAuthModule) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	preModules[name] = module
+}
+
+// RegisterTrace makes a trace module available by name
+func RegisterTrace(name string, module Trace) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	traceModules[name] = module
+}
+
+// RegisterAccount makes an accounting module available by name
+func RegisterAccount(name string, module Account) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	acctModules[name] = module
+}
+
+// Modules lists the registered module names by type
+func Modules() map[string][]string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return map[string][]string{
+		"preauth":    moduleNames(preModules),
+		"trace":      moduleNames(traceModules),
+		"accounting": moduleNames(acctModules),
+	}
+}
+
+func moduleNames(modules interface{}) []string {
+	var names []string
+	switch m := modules.(type) {
+	case map[string]PreAuthModule:
+		for k := range m {
+			names = append(names, k)
+		}
+	case map[string]Trace:
+		for k := range m {
+			names = append(names, k)
+		}
+	case map[string]Account:
+		for k := range m {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newPreAuthChain runs modules in order until one accepts or rejects, a packet no
+// module decides on (all continuing) is rejected
+func newPreAuthChain(names []string) (PreAuth, error) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	var chain []PreAuthModule
+	for _, name := range names {
+		module, ok := preModules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown preauth module: %s", name)
+		}
+		chain = append(chain, module)
+	}
+	return func(p *ClientPacket) bool {
+		for _, module := range chain {
+			switch module(p) {
+			case Accept:
+				return true
+			case Reject:
+				return false
+			}
+		}
+		return false
+	}, nil
+}
+
+func newTraceChain(names []string) (Trace, error) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	var chain []Trace
+	for _, name := range names {
+		module, ok := traceModules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown trace module: %s", name)
+		}
+		chain = append(chain, module)
+	}
+	return func(t TraceType, p *ClientPacket) {
+		for _, module := range chain {
+			module(t, p)
+		}
+	}, nil
+}
+
+func newAccountChain(names []string) (Account, error) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	var chain []Account
+	for _, name := range names {
+		module, ok := acctModules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown accounting module: %s", name)
+		}
+		chain = append(chain, module)
+	}
+	return func(p *ClientPacket) {
+		for _, module := range chain {
+			module(p)
+		}
+	}, nil
+}
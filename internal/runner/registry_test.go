@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPreAuthChain(t *testing.T) {
+	var calls []string
+	module := func(name string, d Decision) PreAuthModule {
+		return func(p *ClientPacket) Decision {
+			calls = append(calls, name)
+			return d
+		}
+	}
+	RegisterPreAuth("test.continue", module("continue", Continue))
+	RegisterPreAuth("test.accept", module("accept", Accept))
+	RegisterPreAuth("test.reject", module("reject", Reject))
+	if _, err := newPreAuthChain([]string{"test.none"}); err == nil {
+		t.Error("unknown module")
+	}
+	for _, c := range []struct {
+		names  []string
+		result bool
+		calls  string
+	}{
+		{[]string{}, false, ""},
+		{[]string{"test.continue"}, false, "continue"},
+		{[]string{"test.continue", "test.accept"}, true, "continue,accept"},
+		{[]string{"test.continue", "test.reject", "test.accept"}, false, "continue,reject"},
+		{[]string{"test.accept", "test.reject"}, true, "accept"},
+		{[]string{"test.continue", "test.continue", "test.reject"}, false, "continue,continue,reject"},
+	} {
+		calls = []string{}
+		chain, err := newPreAuthChain(c.names)
+		if err != nil {
+			t.Error("valid chain")
+		}
+		if chain(nil) != c.result {
+			t.Errorf("invalid result: %v", c.names)
+		}
+		if strings.Join(calls, ",") != c.calls {
+			t.Errorf("invalid calls: %v", calls)
+		}
+	}
+}
+
+func TestTraceAccountChain(t *testing.T) {
+	m := &MockModule{}
+	RegisterTrace("test.trace", m.Trace)
+	RegisterAccount("test.account", m.Account)
+	if _, err := newTraceChain([]string{"test.trace", "test.none"}); err == nil {
+		t.Error("unknown module")
+	}
+	if _, err := newAccountChain([]string{"test.none"}); err == nil {
+		t.Error("unknown module")
+	}
+	trace, err := newTraceChain([]string{"test.trace", "test.trace"})
+	if err != nil {
+		t.Error("valid chain")
+	}
+	trace(TraceRequest, nil)
+	if m.trace != 2 || m.preAuth != 2 {
+		t.Error("invalid trace chain")
+	}
+	acct, err := newAccountChain([]string{"test.account"})
+	if err != nil {
+		t.Error("valid chain")
+	}
+	acct(nil)
+	if m.acct != 1 {
+		t.Error("invalid account chain")
+	}
+	modules := Modules()
+	if !strings.Contains(strings.Join(modules["preauth"], " "), UserMACModule) || modules["trace"][0] != "test.trace" || modules["accounting"][0] != AccountingModule {
+		t.Error("invalid modules")
+	}
+}
@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"bytes"
+	"sync"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+)
+
+const (
+	microsoftVendor = 311
+	mppeSendKey     = 16
+	mppeRecvKey     = 17
+)
+
+type (
+	// Relay re-signs packets between a client's secret and the backend's secret
+	Relay struct {
+		backend  []byte
+		lock     *sync.Mutex
+		requests map[byte]request
+		bindings *tlsBindings
+	}
+
+	request struct {
+		authenticator [authenticatorLength]byte
+		secret        []byte
+	}
+)
+
+// NewRelay creates a relay for a single proxied client
+func (ctx *Context) NewRelay() *Relay {
+	return &Relay{backend: ctx.secret, lock: &sync.Mutex{}, requests: make(map[byte]request), bindings: newTLSBindings()}
+}
+
+// Request converts a client's (pre-authorized) request for the backend
+func (r *Relay) Request(p *ClientPacket) ([]byte, error) {
+	if p.Error != nil || p.Packet == nil {
+		return p.Buffer, nil
+	}
+	r.bindings.request(p.Packet)
+	secret := p.Packet.Secret
+	if bytes.Equal(secret, r.backend) {
+		return p.Buffer, nil
+	}
+	r.lock.Lock()
+	r.requests[p.Packet.Identifier] = request{authenticator: p.Packet.Authenticator, secret: secret}
+	r.lock.Unlock()
+	packet, err := radius.Parse(p.Buffer, secret)
+	if err != nil {
+		return nil, err
+	}
+	if pass, err := rfc2865.UserPassword_Lookup(packet); err == nil {
+		// the password must be padded to a multiple of 16 to be encrypted
+		size := ((len(pass) + 15) / 16) * 16
+		if size == 0 {
+			size = 16
+		}
+		padded := make([]byte, size)
+		copy(padded, pass)
+		a, err := radius.NewUserPassword(padded, r.backend, packet.Authenticator[:])
+		if err != nil {
+			return nil, err
+		}
+		packet.Set(rfc2865.UserPassword_Type, a)
+	}
+	packet.Secret = r.backend
+	if _, ok := packet.Lookup(rfc2869.MessageAuthenticator_Type); ok {
+		packet.Set(rfc2869.MessageAuthenticator_Type, make([]byte, authenticatorLength))
+		b, err := packet.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		packet.Set(rfc2869.MessageAuthenticator_Type, hmacMD5(b, r.backend))
+	}
+	return packet.Encode()
+}
+
+// Response converts a backend response for the client that made the request
+func (r *Relay) Response(b []byte) ([]byte, error) {
+	if len(b) < headerLength {
+		return b, nil
+	}
+	incMetric(responseMetric, "code", radius.Code(b[0]).String(), "source", "backend")
+	rejected, err := r.bindings.response(b)
+	if err != nil {
+		return nil, err
+	}
+	if rejected != nil {
+		return rejected, nil
+	}
+	r.lock.Lock()
+	req, ok := r.requests[b[1]]
+	r.lock.Unlock()
+	if !ok {
+		return b, nil
+	}
+	packet, err := radius.Parse(b, r.backend)
+	if err != nil {
+		return nil, err
+	}
+	for _, avp := range packet.Attributes {
+		if avp.Type != rfc2865.VendorSpecific_Type {
+			continue
+		}
+		converted, err := convertVendor(avp.Attribute, r.backend, req)
+		if err != nil {
+			return nil, err
+		}
+		avp.Attribute = converted
+	}
+	packet.Secret = req.secret
+	return encodeResponse(packet, req.authenticator)
+}
+
+// MS-MPPE keys are salt-encrypted with the secret and must be re-encrypted
+func convertVendor(a radius.Attribute, from []byte, req request) (radius.Attribute, error) {
+	vendor, value, err := radius.VendorSpecific(a)
+	if err != nil || vendor != microsoftVendor {
+		return a, nil
+	}
+	var result []byte
+	for len(value) >= 2 {
+		length := int(value[1])
+		if length < 2 || length > len(value) {
+			return a, nil
+		}
+		sub := value[:length]
+		value = value[length:]
+		if sub[0] == mppeSendKey || sub[0] == mppeRecvKey {
+			key, salt, err := radius.TunnelPassword(sub[2:], from, req.authenticator[:])
+			if err != nil {
+				return nil, err
+			}
+			encrypted, err := radius.NewTunnelPassword(key, salt, req.secret, req.authenticator[:])
+			if err != nil {
+				return nil, err
+			}
+			sub = append([]byte{sub[0], byte(len(encrypted) + 2)}, encrypted...)
+		}
+		result = append(result, sub...)
+	}
+	return radius.NewVendorSpecific(microsoftVendor, result)
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"bytes"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+func TestRelaySameSecret(t *testing.T) {
+	ctx, p := getPacket(t)
+	r := ctx.NewRelay()
+	ctx.packet(p)
+	b, err := r.Request(p)
+	if err != nil || !bytes.Equal(b, p.Buffer) {
+		t.Error("should pass through")
+	}
+	resp := []byte{2, p.Packet.Identifier, 0, 20}
+	resp = append(resp, make([]byte, 16)...)
+	b, err = r.Response(resp)
+	if err != nil || !bytes.Equal(b, resp) {
+		t.Error("should pass through")
+	}
+}
+
+func TestRelayResign(t *testing.T) {
+	client := []byte("client")
+	backend := []byte("backend")
+	ctx := &Context{secret: backend}
+	r := ctx.NewRelay()
+	req := radius.New(radius.CodeAccessRequest, client)
+	pass, err := radius.NewUserPassword([]byte("password\x00\x00\x00\x00\x00\x00\x00\x00"), client, req.Authenticator[:])
+	if err != nil {
+		t.Error("unable to encrypt password")
+	}
+	req.Set(rfc2865.UserPassword_Type, pass)
+	raw, err := encodeResponse(req, req.Authenticator)
+	if err != nil {
+		t.Error("unable to encode")
+	}
+	p := NewClientPacket(raw, nil)
+	p.Packet, p.Error = radius.Parse(raw, client)
+	b, err := r.Request(p)
+	if err != nil {
+		t.Error("unable to resign")
+	}
+	if err := checkMessageAuthenticator(b, backend, true); err != nil {
+		t.Error("backend authenticator")
+	}
+	parsed, err := radius.Parse(b, backend)
+	if err != nil {
+		t.Error("unable to parse")
+	}
+	if pass, err := rfc2865.UserPassword_LookupString(parsed); err != nil || pass != "password" {
+		t.Error("invalid password")
+	}
+	resp := parsed.Response(radius.CodeAccessAccept)
+	if err := microsoft.MSMPPESendKey_Add(resp, []byte("sendkey")); err != nil {
+		t.Error("unable to add key")
+	}
+	if err := microsoft.MSMPPERecvKey_Add(resp, []byte("recvkey")); err != nil {
+		t.Error("unable to add key")
+	}
+	backendResp, err := resp.Encode()
+	if err != nil {
+		t.Error("unable to encode")
+	}
+	b, err = r.Response(backendResp)
+	if err != nil {
+		t.Error("unable to resign")
+	}
+	if !radius.IsAuthenticResponse(b, raw, client) {
+		t.Error("client response authenticator")
+	}
+	out, err := radius.Parse(b, client)
+	if err != nil {
+		t.Error("unable to parse")
+	}
+	if key, err := microsoft.MSMPPESendKey_Lookup(out, req); err != nil || string(key) != "sendkey" {
+		t.Error("invalid send key")
+	}
+	if key, err := microsoft.MSMPPERecvKey_Lookup(out, req); err != nil || string(key) != "recvkey" {
+		t.Error("invalid recv key")
+	}
+}
@@ -1,9 +1,9 @@
 package runner
 
 import (
-	"bytes"
 	"fmt"
 	"net"
+	"sync"
 
 	"layeh.com/radius"
 	"voidedtech.com/dotonex/internal/core"
@@ -14,6 +14,7 @@ const (
 	successCode   ReasonCode = 0
 	badSecretCode ReasonCode = 1
 	preAuthCode   ReasonCode = 2
+	badAuthCode   ReasonCode = 3
 	// NoTrace indicates no tracing to occur
 	NoTrace TraceType = 0
 	// TraceRequest indicate to trace the request
@@ -28,16 +29,21 @@ type (
 
 	// Context is the server's operating context
 	Context struct {
-		Debug    bool
 		secret   []byte
+		clients  []client
 		pre      PreAuth
 		acct     Account
 		trace    Trace
 		noReject bool
+		msgAuth  bool
+		acctSeen *retransmits
 		// shortcuts
 		hasPre   bool
 		hasAcct  bool
 		hasTrace bool
+		// settings (debug, noReject, hasTrace) change while packets are handled
+		settings sync.RWMutex
+		debug    bool
 	}
 
 	// TraceType indicates how to trace a request
@@ -58,6 +64,7 @@ type (
 		Buffer     []byte
 		Packet     *radius.Packet
 		Error      error
+		RadSec     bool
 	}
 )
 
@@ -78,20 +85,23 @@ func (ctx *Context) authorize(packet *ClientPacket) ReasonCode {
 	if packet.Error != nil {
 		return valid
 	}
+	// unknown clients (and forged packets) are not given to modules
 	if err := ctx.checkSecret(packet); err != nil {
-		core.WriteError("invalid radius secret", err)
-		valid = badSecretCode
+		core.WriteError("invalid radius client", err)
+		return badSecretCode
+	}
+	if err := ctx.checkAuthenticator(packet); err != nil {
+		core.WriteError("invalid message authenticator", err)
+		return badAuthCode
 	}
 	if ctx.hasPre {
 		failure := !ctx.pre(packet)
 		if failure {
 			core.WriteDebug("unauthorized (failed preauth)")
-			if valid == successCode {
-				valid = preAuthCode
-			}
+			valid = preAuthCode
 		}
 	}
-	if ctx.hasTrace {
+	if ctx.tracing() {
 		ctx.trace(TraceRequest, packet)
 	}
 	return valid
@@ -99,49 +109,122 @@ func (ctx *Context) authorize(packet *ClientPacket) ReasonCode {
 
 // FromConfig parses config data into a Context object
 func (ctx *Context) FromConfig(c *core.Configuration) {
-	ctx.noReject = c.NoReject
+	ctx.msgAuth = c.RequireMessageAuth
 	ctx.secret = []byte(c.PacketKey)
 	if len(c.PacketKey) == 0 {
 		core.Fatal("invalid packet key", fmt.Errorf("packet key must be set to process packets"))
 	}
+	for _, obj := range c.Clients {
+		cli, err := newClient(obj)
+		if err != nil {
+			core.Fatal("invalid client configuration", err)
+		}
+		ctx.clients = append(ctx.clients, cli)
+	}
 	if c.Accounting {
+		acct, err := newAccountChain(c.Modules.Accounting)
+		if err != nil {
+			core.Fatal("invalid accounting modules", err)
+		}
 		ctx.hasAcct = true
-		ctx.acct = AccountPacket
+		ctx.acct = acct
+		ctx.acctSeen = newRetransmits()
 	} else {
+		pre, err := newPreAuthChain(c.Modules.PreAuth)
+		if err != nil {
+			core.Fatal("invalid preauth modules", err)
+		}
 		ctx.hasPre = true
-		ctx.pre = PrePacket
+		ctx.pre = pre
 	}
-	ctx.hasTrace = !c.NoTrace
-	if ctx.hasTrace {
-		ctx.trace = TracePacket
+	trace, err := newTraceChain(c.Modules.Trace)
+	if err != nil {
+		core.Fatal("invalid trace modules", err)
 	}
+	ctx.trace = trace
+	ctx.Reload(c)
+}
+
+// Reload applies the configuration settings that can change while running
+func (ctx *Context) Reload(c *core.Configuration) {
+	ctx.settings.Lock()
+	defer ctx.settings.Unlock()
+	ctx.noReject = c.NoReject
+	ctx.hasTrace = !c.NoTrace && ctx.trace != nil
+}
+
+// SetDebug changes debugging of packet handling
+func (ctx *Context) SetDebug(debug bool) {
+	ctx.settings.Lock()
+	defer ctx.settings.Unlock()
+	ctx.debug = debug
+}
+
+// Debugging indicates if packet handling is debugging
+func (ctx *Context) Debugging() bool {
+	ctx.settings.RLock()
+	defer ctx.settings.RUnlock()
+	return ctx.debug
+}
+
+func (ctx *Context) rejecting() bool {
+	ctx.settings.RLock()
+	defer ctx.settings.RUnlock()
+	return !ctx.noReject
+}
+
+func (ctx *Context) tracing() bool {
+	ctx.settings.RLock()
+	defer ctx.settings.RUnlock()
+	return ctx.hasTrace
 }
 
 // DebugDump dumps context information for debugging
 func (ctx *Context) DebugDump() {
-	if ctx.Debug {
+	if ctx.Debugging() {
 		core.WriteDebug("secret", string(ctx.secret))
 	}
 }
 
 func (ctx *Context) checkSecret(p *ClientPacket) error {
-	var inSecret []byte
 	if p == nil || p.Packet == nil {
 		return fmt.Errorf("no packet information")
 	}
-	inSecret = p.Packet.Secret
-	if inSecret == nil {
-		return fmt.Errorf("no secret input")
-	}
-	if !bytes.Equal(ctx.secret, inSecret) {
-		return fmt.Errorf("does not match shared secret")
+	if len(p.Packet.Secret) == 0 {
+		addr := "unknown"
+		if p.ClientAddr != nil {
+			addr = p.ClientAddr.String()
+		}
+		return fmt.Errorf("no shared secret for client: %s", addr)
 	}
 	return nil
 }
 
+func (ctx *Context) checkAuthenticator(p *ClientPacket) error {
+	if p.Packet.Code != radius.CodeAccessRequest {
+		return nil
+	}
+	b := p.Buffer
+	if len(b) == 0 {
+		raw, err := p.Packet.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		b = raw
+	}
+	return checkMessageAuthenticator(b, p.Packet.Secret, ctx.msgAuth)
+}
+
 func (ctx *Context) packet(p *ClientPacket) {
 	if p.Error == nil && p.Packet == nil {
-		packet, err := radius.Parse(p.Buffer, ctx.secret)
+		packet, err := radius.Parse(p.Buffer, nil)
+		if err == nil {
+			if p.RadSec {
+				packet.Secret = []byte(RadSecSecret)
+			} else {
+				packet.Secret = ctx.secretFor(p.ClientAddr, packet)
+			}
+		}
 		p.Error = err
 		p.Packet = packet
 	}
@@ -161,30 +244,33 @@ func (ctx *Context) Account(packet *ClientPacket) {
 }
 
 // HandlePreAuth handles the actual pre-authorization checks
-func HandlePreAuth(ctx *Context, b []byte, addr *net.UDPAddr, write writeBack) bool {
-	packet := NewClientPacket(b, addr)
+func HandlePreAuth(ctx *Context, b []byte, addr *net.UDPAddr, write writeBack) (*ClientPacket, bool) {
+	return handlePreAuth(ctx, NewClientPacket(b, addr), write)
+}
+
+func handlePreAuth(ctx *Context, packet *ClientPacket, write writeBack) (*ClientPacket, bool) {
 	authCode := ctx.authorize(packet)
 	authed := authCode == successCode
 	if !authed {
-		if !ctx.noReject && write != nil && authCode != badSecretCode {
+		if ctx.rejecting() && write != nil && authCode != badSecretCode && authCode != badAuthCode {
 			if packet.Error == nil {
 				p := packet.Packet
-				p = p.Response(radius.CodeAccessReject)
-				rej, err := p.Encode()
+				rej, err := encodeResponse(p.Response(radius.CodeAccessReject), p.Authenticator)
 				if err == nil {
 					core.WriteDebug("rejecting client")
+					incMetric(responseMetric, "code", radius.CodeAccessReject.String(), "source", "preauth")
 					write(rej)
 				} else {
-					if ctx.Debug {
+					if ctx.Debugging() {
 						core.WriteError("unable to encode rejection", err)
 					}
 				}
 			} else {
-				if ctx.Debug && packet.Error != nil {
+				if ctx.Debugging() && packet.Error != nil {
 					core.WriteError("unable to parse packets", packet.Error)
 				}
 			}
 		}
 	}
-	return authed
+	return packet, authed
 }
@@ -1,10 +1,12 @@
 package runner
 
 import (
+	"sync"
 	"testing"
 
 	"layeh.com/radius"
 	"layeh.com/radius/rfc2865"
+	"voidedtech.com/dotonex/internal/core"
 )
 
 type MockModule struct {
@@ -151,3 +153,56 @@ func TestAcct(t *testing.T) {
 		t.Error("didn't account")
 	}
 }
+
+func TestContextReload(t *testing.T) {
+	ctx := &Context{trace: TracePacket}
+	c := &core.Configuration{NoReject: true, NoTrace: true}
+	ctx.Reload(c)
+	if !ctx.noReject || ctx.hasTrace {
+		t.Error("invalid reload")
+	}
+	c.NoReject = false
+	c.NoTrace = false
+	ctx.Reload(c)
+	if ctx.noReject || !ctx.hasTrace || ctx.trace == nil {
+		t.Error("invalid reload")
+	}
+}
+
+// settings change (reload, debug) while packets are handled, run with -race
+func TestReloadWhileHandling(t *testing.T) {
+	ctx, p := getPacket(t)
+	ctx.trace = func(TraceType, *ClientPacket) {}
+	ctx.hasPre = true
+	ctx.pre = func(*ClientPacket) bool {
+		return false
+	}
+	done := make(chan bool)
+	started := make(chan bool)
+	wait := &sync.WaitGroup{}
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		c := &core.Configuration{}
+		for i := 0; ; i++ {
+			if i == 1 {
+				close(started)
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+			c.NoReject = i%2 == 0
+			c.NoTrace = i%3 == 0
+			ctx.Reload(c)
+			ctx.SetDebug(i%2 == 1)
+		}
+	}()
+	<-started
+	for i := 0; i < 1000; i++ {
+		handlePreAuth(ctx, NewClientPacket(p.Buffer, nil), func([]byte) {})
+	}
+	close(done)
+	wait.Wait()
+}
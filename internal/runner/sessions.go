@@ -0,0 +1,223 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+	"voidedtech.com/dotonex/internal/core"
+)
+
+var (
+	sessionLock = new(sync.Mutex)
+	sessions    = make(map[string]*Session)
+	sessionFile = ""
+	sessionTTL  time.Duration
+)
+
+type (
+	// Session is an active accounting session on a NAS
+	Session struct {
+		ID             string
+		User           string
+		CallingStation string
+		NASID          string
+		NASIP          string
+		NASPort        uint32
+		NASPortID      string
+		Start          time.Time
+		Updated        time.Time
+		InputOctets    uint64
+		OutputOctets   uint64
+		InputPackets   uint32
+		OutputPackets  uint32
+	}
+)
+
+func sessionKey(nasip, id string) string {
+	return fmt.Sprintf("%s/%s", nasip, id)
+}
+
+// ConfigureSessions enables session tracking, loading any previously persisted sessions
+func ConfigureSessions(file string, timeout time.Duration) error {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	sessionFile = file
+	sessionTTL = timeout
+	if !core.PathExists(file) {
+		return nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var loaded []*Session
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		return err
+	}
+	for _, s := range loaded {
+		sessions[sessionKey(s.NASIP, s.ID)] = s
+	}
+	return nil
+}
+
+func trackSession(packet *ClientPacket) {
+	p := packet.Packet
+	id := rfc2866.AcctSessionID_GetString(p)
+	status := rfc2866.AcctStatusType_Get(p)
+	if id == "" && status != rfc2866.AcctStatusType_Value_AccountingOn && status != rfc2866.AcctStatusType_Value_AccountingOff {
+		return
+	}
+	nas, nasip := nasInfo(packet)
+	key := sessionKey(nasip, id)
+	now := time.Now()
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	if sessionFile == "" {
+		return
+	}
+	switch status {
+	case rfc2866.AcctStatusType_Value_Start, rfc2866.AcctStatusType_Value_InterimUpdate:
+		s, ok := sessions[key]
+		if !ok {
+			s = &Session{ID: id, Start: now}
+			elapsed := time.Duration(rfc2866.AcctSessionTime_Get(p)) * time.Second
+			if elapsed > 0 {
+				s.Start = now.Add(-elapsed)
+			}
+			sessions[key] = s
+		}
+		s.User = rfc2865.UserName_GetString(p)
+		s.CallingStation = rfc2865.CallingStationID_GetString(p)
+		s.NASID = nas
+		s.NASIP = nasip
+		s.NASPort = uint32(rfc2865.NASPort_Get(p))
+		s.NASPortID = rfc2869.NASPortID_GetString(p)
+		s.Updated = now
+		s.InputOctets = uint64(rfc2869.AcctInputGigawords_Get(p))<<32 | uint64(rfc2866.AcctInputOctets_Get(p))
+		s.OutputOctets = uint64(rfc2869.AcctOutputGigawords_Get(p))<<32 | uint64(rfc2866.AcctOutputOctets_Get(p))
+		s.InputPackets = uint32(rfc2866.AcctInputPackets_Get(p))
+		s.OutputPackets = uint32(rfc2866.AcctOutputPackets_Get(p))
+	case rfc2866.AcctStatusType_Value_Stop:
+		delete(sessions, key)
+	case rfc2866.AcctStatusType_Value_AccountingOn, rfc2866.AcctStatusType_Value_AccountingOff:
+		// the NAS has (re)started or is stopping, none of its sessions remain
+		for k, s := range sessions {
+			if s.NASIP == nasip {
+				delete(sessions, k)
+			}
+		}
+	}
+}
+
+// ExpireSessions closes out sessions that have not been updated within the timeout
+func ExpireSessions() {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	if sessionTTL <= 0 {
+		return
+	}
+	for key, s := range sessions {
+		if time.Since(s.Updated) > sessionTTL {
+			kv := keyValueStore{}
+			kv.add("Result", "EXPIRED")
+			kv.add("Session", s.ID)
+			kv.add("User-Name", s.User)
+			kv.add("Calling-Station-Id", s.CallingStation)
+			kv.add("NAS-IPAddress", s.NASIP)
+			logPluginMessages("session", kv)
+			delete(sessions, key)
+		}
+	}
+}
+
+// Sessions gets the active sessions that match a filter (or all when nil)
+func Sessions(filter func(Session) bool) []Session {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	results := []Session{}
+	for _, s := range sessions {
+		if filter == nil || filter(*s) {
+			results = append(results, *s)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].NASIP == results[j].NASIP {
+			return results[i].NASPort < results[j].NASPort
+		}
+		return results[i].NASIP < results[j].NASIP
+	})
+	return results
+}
+
+// WriteSessions persists the active sessions to disk
+func WriteSessions() {
+	sessionLock.Lock()
+	file := sessionFile
+	sessionLock.Unlock()
+	if file == "" {
+		return
+	}
+	b, err := json.MarshalIndent(Sessions(nil), "", "  ")
+	if err != nil {
+		core.WriteError("unable to serialize sessions", err)
+		return
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		core.WriteError("unable to write sessions", err)
+		return
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		core.WriteError("unable to save sessions", err)
+	}
+}
+
+// sessionUser gets the user of a session login (tokens are not shown)
+func sessionUser(login string) string {
+	if user, _ := core.GetTokenFromLogin(login); user != "" {
+		return user
+	}
+	return core.GetUserFromLogin(login)
+}
+
+// SessionsCommand lists the active sessions, optionally of a MAC or a user
+func SessionsCommand(args []string) (string, error) {
+	if len(args) > 1 {
+		return "", fmt.Errorf("sessions takes a mac or a user")
+	}
+	sessionLock.Lock()
+	enabled := sessionFile != ""
+	sessionLock.Unlock()
+	if !enabled {
+		return "", fmt.Errorf("sessions are not tracked")
+	}
+	var filter func(Session) bool
+	if len(args) == 1 {
+		search := args[0]
+		mac, isMAC := core.CleanMAC(search)
+		filter = func(s Session) bool {
+			if isMAC {
+				if calling, ok := core.CleanMAC(s.CallingStation); ok && calling == mac {
+					return true
+				}
+			}
+			return sessionUser(s.User) == search
+		}
+	}
+	var lines []string
+	for _, s := range Sessions(filter) {
+		lines = append(lines, fmt.Sprintf("%s %s (%s) on %s port %d since %s (in: %d, out: %d)", s.ID, sessionUser(s.User), s.CallingStation, s.NASIP, s.NASPort, core.LogTime(s.Start), s.InputOctets, s.OutputOctets))
+	}
+	if len(lines) == 0 {
+		return "no sessions", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
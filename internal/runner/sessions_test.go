@@ -0,0 +1,152 @@
+package runner
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+)
+
+func newSessionPacket(t *testing.T, id string, status rfc2866.AcctStatusType, port int) *ClientPacket {
+	p := radius.New(radius.CodeAccountingRequest, []byte("secret"))
+	if err := rfc2865.UserName_AddString(p, "user"); err != nil {
+		t.Error("unable to add user name")
+	}
+	if err := rfc2866.AcctStatusType_Add(p, status); err != nil {
+		t.Error("unable to add status")
+	}
+	if err := rfc2866.AcctSessionID_AddString(p, id); err != nil {
+		t.Error("unable to add session")
+	}
+	if err := rfc2865.NASPort_Add(p, rfc2865.NASPort(port)); err != nil {
+		t.Error("unable to add port")
+	}
+	if err := rfc2865.NASIPAddress_Add(p, net.IPv4(10, 0, 0, 1)); err != nil {
+		t.Error("unable to add nas ip")
+	}
+	packet := NewClientPacket(nil, nil)
+	packet.Packet = p
+	return packet
+}
+
+func resetSessions(file string) {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	sessions = make(map[string]*Session)
+	sessionFile = file
+}
+
+func TestTrackSession(t *testing.T) {
+	resetSessions("")
+	trackSession(newSessionPacket(t, "a", rfc2866.AcctStatusType_Value_Start, 1))
+	if len(Sessions(nil)) != 0 {
+		t.Error("tracking disabled")
+	}
+	file := filepath.Join(t.TempDir(), "sessions.json")
+	if err := ConfigureSessions(file, time.Minute); err != nil {
+		t.Error("unable to configure")
+	}
+	trackSession(newSessionPacket(t, "a", rfc2866.AcctStatusType_Value_Start, 12))
+	trackSession(newSessionPacket(t, "b", rfc2866.AcctStatusType_Value_Start, 2))
+	interim := newSessionPacket(t, "a", rfc2866.AcctStatusType_Value_InterimUpdate, 12)
+	if err := rfc2866.AcctInputOctets_Add(interim.Packet, 5); err != nil {
+		t.Error("unable to add octets")
+	}
+	if err := rfc2869.AcctInputGigawords_Add(interim.Packet, 1); err != nil {
+		t.Error("unable to add gigawords")
+	}
+	trackSession(interim)
+	all := Sessions(nil)
+	if len(all) != 2 || all[0].ID != "b" || all[1].ID != "a" {
+		t.Error("invalid sessions")
+	}
+	if all[1].InputOctets != 1<<32+5 {
+		t.Error("invalid octets")
+	}
+	port := Sessions(func(s Session) bool {
+		return s.NASIP == "10.0.0.1" && s.NASPort == 12
+	})
+	if len(port) != 1 || port[0].User != "user" {
+		t.Error("invalid port session")
+	}
+	WriteSessions()
+	trackSession(newSessionPacket(t, "a", rfc2866.AcctStatusType_Value_Stop, 12))
+	if len(Sessions(nil)) != 1 {
+		t.Error("session should stop")
+	}
+	resetSessions("")
+	if err := ConfigureSessions(file, time.Minute); err != nil {
+		t.Error("unable to reload")
+	}
+	if len(Sessions(nil)) != 2 {
+		t.Error("sessions should reload")
+	}
+	trackSession(newSessionPacket(t, "", rfc2866.AcctStatusType_Value_AccountingOn, 0))
+	if len(Sessions(nil)) != 0 {
+		t.Error("accounting on clears the nas")
+	}
+	resetSessions("")
+}
+
+func TestExpireSessions(t *testing.T) {
+	resetSessions("")
+	if err := ConfigureSessions(filepath.Join(t.TempDir(), "sessions.json"), time.Minute); err != nil {
+		t.Error("unable to configure")
+	}
+	trackSession(newSessionPacket(t, "a", rfc2866.AcctStatusType_Value_Start, 1))
+	trackSession(newSessionPacket(t, "b", rfc2866.AcctStatusType_Value_Start, 2))
+	sessionLock.Lock()
+	for _, s := range sessions {
+		if s.ID == "a" {
+			s.Updated = time.Now().Add(-2 * time.Minute)
+		}
+	}
+	sessionLock.Unlock()
+	ExpireSessions()
+	all := Sessions(nil)
+	if len(all) != 1 || all[0].ID != "b" {
+		t.Error("stale session should expire")
+	}
+	resetSessions("")
+}
+
+func TestSessionsCommand(t *testing.T) {
+	resetSessions("")
+	defer resetSessions("")
+	if _, err := SessionsCommand(nil); err == nil {
+		t.Error("not tracked")
+	}
+	resetSessions(filepath.Join(t.TempDir(), "sessions.json"))
+	if res, err := SessionsCommand(nil); err != nil || res != "no sessions" {
+		t.Errorf("no sessions: %s (%v)", res, err)
+	}
+	addSession(Session{ID: "a", User: "user.name:token@vlan.abc", CallingStation: "11-22-33-44-55-66", NASIP: "10.0.0.1", NASPort: 1})
+	addSession(Session{ID: "b", User: "AABBCCDDEEFF", CallingStation: "aabbccddeeff", NASIP: "10.0.0.1", NASPort: 2})
+	addSession(Session{ID: "c", User: "cert.name", CallingStation: "665544332211", NASIP: "10.0.0.2", NASPort: 1})
+	res, err := SessionsCommand(nil)
+	if err != nil || len(strings.Split(res, "\n")) != 3 || strings.Contains(res, "token") {
+		t.Errorf("invalid sessions: %s (%v)", res, err)
+	}
+	res, err = SessionsCommand([]string{"user.name"})
+	if err != nil || !strings.HasPrefix(res, "a user.name (11-22-33-44-55-66) on 10.0.0.1 port 1") || strings.Contains(res, "\n") {
+		t.Errorf("invalid user sessions: %s (%v)", res, err)
+	}
+	for _, search := range []string{"AA:BB:CC:DD:EE:FF", "cert.name"} {
+		res, err = SessionsCommand([]string{search})
+		if err != nil || strings.Count(res, " on ") != 1 {
+			t.Errorf("invalid sessions for %s: %s (%v)", search, res, err)
+		}
+	}
+	if res, err := SessionsCommand([]string{"other.name"}); err != nil || res != "no sessions" {
+		t.Errorf("no sessions: %s (%v)", res, err)
+	}
+	if _, err := SessionsCommand([]string{"a", "b"}); err == nil {
+		t.Error("invalid arguments")
+	}
+}
@@ -1,9 +1,8 @@
 package runner
 
 import (
-	"bytes"
-	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"regexp"
@@ -11,64 +10,41 @@ import (
 	"sync"
 	"time"
 
+	"voidedtech.com/dotonex/internal/compose"
 	"voidedtech.com/dotonex/internal/core"
 )
 
 var (
-	callLock = &sync.Mutex{}
-	backend  *script
+	// lookups share the backend, updates (build, reconfigure) are exclusive
+	callLock   = &sync.RWMutex{}
+	updateLock = &sync.Mutex{}
+	backend    *script
+	polling    = false
+	workers    = newWorkers(4)
+	inflight   = newFlights()
+	// the store generation changes when users are added or revoked (e.g. by the CLI)
+	generationLock     = &sync.Mutex{}
+	generationChecked  time.Time
+	lastGeneration     string
+	generationInterval = 5 * time.Second
 )
 
 type (
 	script struct {
-		cfg     core.Composition
-		hash    string
-		static  bool
-		timeout time.Duration
-		payload []string
-		env     []string
-		regex   *regexp.Regexp
+		cfg      core.Composition
+		hash     string
+		static   bool
+		payload  []string
+		regex    *regexp.Regexp
+		composer composer
 	}
 )
 
-func (s script) execute(flags core.ComposeFlags) bool {
-	flags.Repo = s.cfg.Repository
-	arguments := flags.Args()
-
-	if s.cfg.Debug {
-		core.WriteInfo(fmt.Sprintf("running: %v", arguments))
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, s.cfg.Binary, arguments...)
-	if len(s.env) > 0 {
-		cmd.Env = s.env
-	}
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	out, err := cmd.Output()
-	if ctx.Err() == context.DeadlineExceeded {
-		core.WriteWarn("script timeout")
-		return false
-	}
-	str := stderr.String()
-	if len(str) > 0 {
-		core.WriteInfo("stderr")
-		core.WriteInfo(str)
-	}
-	if s.cfg.Debug {
-		str = strings.TrimSpace(string(out))
-		if len(str) > 0 {
-			core.WriteInfo("stdout")
-			core.WriteInfo(str)
-		}
-	}
-	if err != nil {
+func (s script) execute(mode string, call func(composer) error) bool {
+	defer observeScript(mode, time.Now())
+	if err := call(s.composer); err != nil {
 		core.WriteError("script result", err)
-		if exitError, ok := err.(*exec.ExitError); ok {
-			return exitError.ExitCode() == 0
-		}
+		return false
 	}
 	return true
 }
@@ -83,8 +59,9 @@ func (s script) MAC(mac string) bool {
 		}
 		return false
 	}
-	c := core.ComposeFlags{Mode: core.ModeMAC, MAC: mac}
-	return s.execute(c)
+	return s.execute(core.ModeMAC, func(c composer) error {
+		return c.MAC(mac)
+	})
 }
 
 func (s script) Validate(user, token, mac string) bool {
@@ -102,21 +79,41 @@ func (s script) Validate(user, token, mac string) bool {
 			return false
 		}
 	}
-	c := core.ComposeFlags{Mode: core.ModeValidate, MAC: mac, Token: token, Command: s.cfg.Payload}
-	return s.execute(c)
+	return s.execute(core.ModeValidate, func(c composer) error {
+		return c.Validate(token, mac)
+	})
+}
+
+func (s script) TLS(user, mac string) bool {
+	if s.static {
+		return false
+	}
+	if s.regex != nil {
+		if !s.regex.MatchString(user) {
+			return false
+		}
+	}
+	return s.execute(core.ModeTLS, func(c composer) error {
+		return c.TLS(user, mac)
+	})
 }
 
 func (s script) Server() bool {
-	c := core.ComposeFlags{Mode: core.ModeServer, Hash: s.hash}
-	return s.execute(c)
+	return s.execute(core.ModeServer, func(c composer) error {
+		return c.Server(s.hash)
+	})
 }
 
 func (s script) Fetch() bool {
-	return s.execute(core.ComposeFlags{Mode: core.ModeFetch})
+	return s.execute(core.ModeFetch, func(c composer) error {
+		return c.Fetch()
+	})
 }
 
 func (s script) Build() bool {
-	return s.execute(core.ComposeFlags{Mode: core.ModeBuild})
+	return s.execute(core.ModeBuild, func(c composer) error {
+		return c.Build()
+	})
 }
 
 // SetAllowed hard sets which token+mac combos are allowed
@@ -130,67 +127,315 @@ func SetAllowed(payload []string) {
 	}
 	callLock.Lock()
 	defer callLock.Unlock()
+	backend.close()
 	backend = &script{payload: objects, static: true}
+	workers = newWorkers(1)
+	// static payloads are checked in memory, nothing to cache
+	decisions.configure(0, 0)
 }
 
-// Manage configures the backend for access checks
-func Manage(cfg *core.Configuration) error {
-	if len(cfg.Compose.Payload) == 0 {
-		return fmt.Errorf("no command configured for management")
+func newWorkers(count int) chan bool {
+	if count < 1 {
+		count = 1
+	}
+	return make(chan bool, count)
+}
+
+func configureCache(cfg *core.Configuration) {
+	if cfg.Compose.Cache.Disable {
+		decisions.configure(0, 0)
+		return
+	}
+	decisions.configure(time.Duration(cfg.Compose.Cache.Positive)*time.Second, time.Duration(cfg.Compose.Cache.Negative)*time.Second)
+}
+
+func newScript(cfg *core.Configuration) (*script, error) {
+	if len(cfg.Compose.Payload) == 0 && cfg.Compose.HTTP.URL == "" && cfg.Compose.Introspect.URL == "" {
+		return nil, fmt.Errorf("no command configured for management")
 	}
 	if len(cfg.Compose.ServerKey) == 0 {
-		return fmt.Errorf("no server key/passphrase found")
+		return nil, fmt.Errorf("no server key/passphrase found")
 	}
 	var regex *regexp.Regexp
 	if len(cfg.Compose.UserRegex) > 0 {
-		regex = regexp.MustCompile(cfg.Compose.UserRegex)
+		r, err := regexp.Compile(cfg.Compose.UserRegex)
+		if err != nil {
+			return nil, err
+		}
+		regex = r
 	}
 	hashed, err := core.MD4(cfg.Compose.ServerKey)
+	if err != nil {
+		return nil, err
+	}
+	timeout := time.Duration(cfg.Compose.Timeout) * time.Second
+	var calls composer
+	if cfg.Compose.Binary == "" {
+		flags := core.ComposeFlags{Repo: cfg.Compose.Repository,
+			Search:     cfg.Compose.Search,
+			Debug:      cfg.Compose.Debug,
+			HTTP:       cfg.Compose.HTTP,
+			Introspect: cfg.Compose.Introspect,
+			Require:    cfg.Compose.Require,
+			LDAP:       cfg.Compose.LDAP,
+			Store:      cfg.Compose.Store,
+			Revalidate: cfg.Compose.Revalidate,
+			Passwords:  cfg.Compose.Passwords,
+			Key:        cfg.Compose.ServerKey,
+			Method:     cfg.Compose.Method,
+			Command:    cfg.Compose.Payload}
+		opened, err := compose.Open(flags, timeout)
+		if err != nil {
+			return nil, err
+		}
+		calls = opened
+	} else {
+		calls = execComposer{cfg: cfg.Compose, env: cfg.Compose.ToEnv(os.Environ()), timeout: timeout}
+	}
+	return &script{regex: regex, cfg: cfg.Compose, hash: hashed, composer: calls}, nil
+}
+
+// close releases an in-process composition backend
+func (s *script) close() {
+	if s == nil {
+		return
+	}
+	if closer, ok := s.composer.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			core.WriteError("unable to close composition", err)
+		}
+	}
+}
+
+// Manage configures the backend for access checks
+func Manage(cfg *core.Configuration) error {
+	managed, err := newScript(cfg)
 	if err != nil {
 		return err
 	}
-	backend = &script{regex: regex, env: cfg.Compose.ToEnv(os.Environ()), cfg: cfg.Compose, timeout: time.Duration(cfg.Compose.Timeout) * time.Second, hash: hashed}
 	callLock.Lock()
+	backend.close()
+	backend = managed
+	workers = newWorkers(cfg.Compose.Workers)
 	result := backend.Server()
 	callLock.Unlock()
 	if !result {
 		return fmt.Errorf("server command failed")
 	}
-	if cfg.Compose.Polling {
-		core.WriteInfo("starting git runner")
-		go run(time.Duration(cfg.Compose.Refresh) * time.Minute)
+	configureCache(cfg)
+	startPolling(cfg)
+	return nil
+}
+
+// Reconfigure changes the backend for access checks while running
+func Reconfigure(cfg *core.Configuration) error {
+	if cfg.Compose.Static {
+		SetAllowed(cfg.Compose.Payload)
+		return nil
+	}
+	managed, err := newScript(cfg)
+	if err != nil {
+		return err
+	}
+	callLock.Lock()
+	if backend == nil || backend.static || backend.hash != managed.hash {
+		if !managed.Server() {
+			callLock.Unlock()
+			managed.close()
+			return fmt.Errorf("server command failed")
+		}
 	}
+	backend.close()
+	backend = managed
+	workers = newWorkers(cfg.Compose.Workers)
+	callLock.Unlock()
+	configureCache(cfg)
+	startPolling(cfg)
 	return nil
 }
 
+func startPolling(cfg *core.Configuration) {
+	if !cfg.Compose.Polling {
+		return
+	}
+	callLock.Lock()
+	started := polling
+	polling = true
+	callLock.Unlock()
+	if started {
+		return
+	}
+	core.WriteInfo("starting git runner")
+	go run()
+}
+
 // CheckMAC validates a MAC
 func CheckMAC(mac string) bool {
-	callLock.Lock()
-	defer callLock.Unlock()
-	return backend.MAC(mac)
+	valid, _ := checkMAC(mac)
+	return valid
+}
+
+func checkMAC(mac string) (bool, bool) {
+	return lookup(decisionKey("", "", mac), func(s *script) bool {
+		return s.MAC(mac)
+	})
 }
 
 // CheckTokenMAC validates a token+mac combination as valid
 func CheckTokenMAC(user, token, mac string) bool {
-	callLock.Lock()
-	defer callLock.Unlock()
-	return backend.Validate(user, token, mac)
+	valid, _ := checkTokenMAC(user, token, mac)
+	return valid
+}
+
+func checkTokenMAC(user, token, mac string) (bool, bool) {
+	return lookup(decisionKey(user, token, mac), func(s *script) bool {
+		return s.Validate(user, token, mac)
+	})
+}
+
+// CheckTLSMAC validates a certificate (TLS) user+mac combination as valid
+func CheckTLSMAC(user, mac string) bool {
+	valid, _ := checkTLSMAC(user, mac)
+	return valid
+}
+
+func checkTLSMAC(user, mac string) (bool, bool) {
+	return lookup(fmt.Sprintf("tls:%s", decisionKey(user, "", mac)), func(s *script) bool {
+		return s.TLS(user, mac)
+	})
+}
+
+// tlsLogins checks if certificate (TLS) logins, without a token, are allowed
+func tlsLogins() bool {
+	callLock.RLock()
+	defer callLock.RUnlock()
+	return backend != nil && !backend.static && backend.cfg.TLS
+}
+
+// lookup checks the cache, otherwise the backend is called (by a bounded number of workers)
+// and concurrent lookups of the same key wait on the same call
+func lookup(key string, check func(*script) bool) (bool, bool) {
+	checkGeneration()
+	if valid, ok := decisions.get(key); ok {
+		return valid, true
+	}
+	valid := inflight.do(key, func() bool {
+		callLock.RLock()
+		defer callLock.RUnlock()
+		pool := workers
+		pool <- true
+		defer func() {
+			<-pool
+		}()
+		result := check(backend)
+		// cached before an update can clear the cache
+		decisions.set(key, result)
+		return result
+	})
+	return valid, false
+}
+
+// checkGeneration clears cached decisions when the store generation changed, the
+// store is checked at most once per interval
+func checkGeneration() {
+	generationLock.Lock()
+	due := time.Since(generationChecked) >= generationInterval
+	if due {
+		generationChecked = time.Now()
+	}
+	generationLock.Unlock()
+	if !due {
+		return
+	}
+	managed, gen, err := func() (bool, string, error) {
+		// a reconfigure closes the backend it replaces
+		callLock.RLock()
+		defer callLock.RUnlock()
+		if backend == nil || backend.static {
+			return false, "", nil
+		}
+		gen, err := backend.composer.Generation()
+		return true, gen, err
+	}()
+	if !managed {
+		return
+	}
+	if err != nil {
+		core.WriteError("unable to read store generation", err)
+		return
+	}
+	generationLock.Lock()
+	defer generationLock.Unlock()
+	if gen == lastGeneration {
+		return
+	}
+	if lastGeneration != "" {
+		core.WriteInfo("store changed, clearing cached decisions")
+	}
+	decisions.clear()
+	lastGeneration = gen
+}
+
+func (s script) version() string {
+	out, err := exec.Command("git", "-C", s.cfg.Repository, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
 }
 
 func fetchBuild() bool {
-	callLock.Lock()
-	defer callLock.Unlock()
-	if !backend.Fetch() {
+	updateLock.Lock()
+	defer updateLock.Unlock()
+	callLock.RLock()
+	current := backend
+	callLock.RUnlock()
+	last := current.version()
+	// lookups continue while fetching, only the build is exclusive
+	if !current.Fetch() {
 		core.WriteWarn("fetch failed")
 		return false
 	}
+	callLock.Lock()
+	defer callLock.Unlock()
 	result := backend.Build()
+	if current := backend.version(); current == "" || current != last {
+		core.WriteInfo("configuration changed, clearing cached decisions")
+		decisions.clear()
+	}
 	return result
 }
 
-func run(sleep time.Duration) {
+// Refresh forces a fetch and build of the managed configuration
+func Refresh() error {
+	callLock.RLock()
+	managed := backend != nil && !backend.static
+	callLock.RUnlock()
+	if !managed {
+		return fmt.Errorf("no managed configuration backend")
+	}
+	if !fetchBuild() {
+		return fmt.Errorf("fetch and build failed")
+	}
+	return nil
+}
+
+func run() {
 	for {
+		callLock.RLock()
+		sleep := time.Duration(backend.cfg.Refresh) * time.Minute
+		callLock.RUnlock()
+		if sleep <= 0 {
+			sleep = time.Minute
+		}
 		time.Sleep(sleep)
+		callLock.RLock()
+		enabled := !backend.static && backend.cfg.Polling
+		callLock.RUnlock()
+		if !enabled {
+			// polling was disabled by a reload
+			continue
+		}
 		core.WriteInfo("running fetch and update")
 		result := fetchBuild()
 		if !result {
@@ -202,4 +447,5 @@ func run(sleep time.Duration) {
 // ShutdownValidator should be called when we're exiting
 func ShutdownValidator() {
 	callLock.Lock()
+	backend.close()
 }
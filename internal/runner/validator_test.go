@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"voidedtech.com/dotonex/internal/core"
+)
+
+func TestReconfigure(t *testing.T) {
+	c := &core.Configuration{}
+	c.Compose.Static = true
+	c.Compose.Payload = []string{"test/112233445566"}
+	if err := Reconfigure(c); err != nil {
+		t.Error("static should reconfigure")
+	}
+	if !CheckMAC("112233445566") || CheckMAC("112233445567") {
+		t.Error("invalid static payload")
+	}
+	c.Compose.Payload = []string{"test/112233445567"}
+	if err := Reconfigure(c); err != nil {
+		t.Error("static should reconfigure")
+	}
+	if CheckMAC("112233445566") || !CheckMAC("112233445567") {
+		t.Error("payload not reloaded")
+	}
+	c.Compose.Static = false
+	if err := Reconfigure(c); err == nil {
+		t.Error("no server key")
+	}
+	c.Compose.ServerKey = "key"
+	c.Compose.UserRegex = "["
+	if err := Reconfigure(c); err == nil {
+		t.Error("invalid regex")
+	}
+	if !CheckMAC("112233445567") {
+		t.Error("failed reconfigure should keep backend")
+	}
+}
+
+func TestLookupWorkers(t *testing.T) {
+	SetAllowed([]string{})
+	callLock.Lock()
+	workers = newWorkers(2)
+	callLock.Unlock()
+	defer SetAllowed([]string{})
+	lock := &sync.Mutex{}
+	running := 0
+	most := 0
+	done := make(chan bool)
+	for i := 0; i < 6; i++ {
+		key := fmt.Sprintf("%d", i)
+		go func() {
+			lookup(key, func(s *script) bool {
+				lock.Lock()
+				running++
+				if running > most {
+					most = running
+				}
+				lock.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				lock.Lock()
+				running--
+				lock.Unlock()
+				return true
+			})
+			done <- true
+		}()
+	}
+	for i := 0; i < 6; i++ {
+		<-done
+	}
+	if most != 2 {
+		t.Errorf("workers not bounded: %d", most)
+	}
+	if newWorkers(0) == nil || cap(newWorkers(0)) != 1 || cap(newWorkers(3)) != 3 {
+		t.Error("invalid workers")
+	}
+}
+
+type generationComposer struct {
+	execComposer
+	lock       *sync.Mutex
+	generation string
+}
+
+func (c *generationComposer) Generation() (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.generation, nil
+}
+
+func (c *generationComposer) MAC(mac string) error {
+	return nil
+}
+
+func TestGenerationClearsCache(t *testing.T) {
+	fake := &generationComposer{lock: &sync.Mutex{}, generation: "1"}
+	callLock.Lock()
+	backend = &script{composer: fake}
+	callLock.Unlock()
+	decisions.configure(time.Minute, time.Minute)
+	generationInterval = 0
+	defer func() {
+		generationInterval = 5 * time.Second
+		SetAllowed([]string{})
+	}()
+	if valid, cached := checkMAC("112233445566"); !valid || cached {
+		t.Error("should check backend")
+	}
+	if _, cached := checkMAC("112233445566"); !cached {
+		t.Error("should be cached")
+	}
+	fake.lock.Lock()
+	fake.generation = "2"
+	fake.lock.Unlock()
+	if _, cached := checkMAC("112233445566"); cached {
+		t.Error("store changed, cache should be cleared")
+	}
+	if _, cached := checkMAC("112233445566"); !cached {
+		t.Error("should be cached")
+	}
+}
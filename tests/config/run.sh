@@ -18,7 +18,7 @@ _command() {
 }
 
 _read() {
-    go run db.go -database $DB | sort > $DBLOG
+    go run db.go -database $DB | sed 's/=>validated:::[0-9]*$/=>validated:::TIME/g' | sort > $DBLOG
 }
 
 _diff() {
@@ -54,7 +54,7 @@ _diff_db serverhash
 SET_USER=user.name
 _command validate --token abcdef --mac aabbccddeeff
 _diff_db token1
-_command validate --token token --mac aabbccddeeff
+DOTONEX_TOKEN=token _command validate --mac aabbccddeeff
 _diff_db token2
 _command validate --token abcdef --mac aabbccddeeff
 _diff_db token2
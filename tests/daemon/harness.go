@@ -1,10 +1,21 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
 	"flag"
 	"fmt"
+	"io"
+	"math/big"
 	"net"
 	"os"
+	"path/filepath"
 	"time"
 
 	"layeh.com/radius"
@@ -12,8 +23,15 @@ import (
 )
 
 func newPacket(user, mac string, ip net.IP) []byte {
-	var secret = []byte("secret")
-	p := radius.New(radius.CodeAccessRequest, secret)
+	return newSecretPacket(user, mac, ip, []byte("secret"))
+}
+
+func newSecretPacket(user, mac string, ip net.IP, secret []byte) []byte {
+	return newCodePacket(radius.CodeAccessRequest, user, mac, ip, secret)
+}
+
+func newCodePacket(code radius.Code, user, mac string, ip net.IP, secret []byte) []byte {
+	p := radius.New(code, secret)
 	if err := rfc2865.UserName_AddString(p, user); err != nil {
 		panic("unable to set attribute: user-name")
 	}
@@ -56,8 +74,31 @@ func runEndpoint() {
 	}
 }
 
-func write(user, mac string, conn *net.UDPConn, nasip net.IP) {
+func write(user, mac string, conn *net.UDPConn, nasip net.IP, accounting bool) {
 	time.Sleep(1 * time.Second)
+	if accounting {
+		// send the request and a retransmit, both should be answered
+		p := newCodePacket(radius.CodeAccountingRequest, user, mac, nasip, []byte("secret"))
+		responses := 0
+		for i := 0; i < 2; i++ {
+			if _, err := conn.Write(p); err != nil {
+				panic("unable to write")
+			}
+			if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+				panic("unable to set deadline")
+			}
+			var buffer [radius.MaxPacketLength]byte
+			n, err := conn.Read(buffer[0:])
+			if err != nil {
+				continue
+			}
+			if radius.IsAuthenticResponse(buffer[0:n], p, []byte("secret")) {
+				responses++
+			}
+		}
+		increment("accounting", responses)
+		return
+	}
 	p := newPacket(user, mac, nasip)
 	_, err := conn.Write(p)
 	if err != nil {
@@ -65,6 +106,20 @@ func write(user, mac string, conn *net.UDPConn, nasip net.IP) {
 	}
 }
 
+func increment(name string, count int) {
+	file := filepath.Join("bin", name)
+	existing, err := os.ReadFile(file)
+	if err == nil {
+		var previous int
+		if _, err := fmt.Sscanf(string(existing), name+":%d", &previous); err == nil {
+			count += previous
+		}
+	}
+	if err := os.WriteFile(file, []byte(fmt.Sprintf("%s:%d", name, count)), 0644); err != nil {
+		panic("write failed")
+	}
+}
+
 func test(accounting bool) {
 	bind := "1812"
 	if accounting {
@@ -79,19 +134,122 @@ func test(accounting bool) {
 		panic("unable to dial")
 	}
 	for _, ip := range []net.IP{net.IPv4(127, 0, 0, 1)} {
-		write("user:test", "11-22-33-44-55-66", srv, ip)
-		write("user:test@vlan.id", "11-22-33-44-55-67", srv, ip)
-		write("user:test", "11-22-33-44-55-66", srv, ip)
+		write("user:test", "11-22-33-44-55-66", srv, ip, accounting)
+		write("user:test@vlan.id", "11-22-33-44-55-67", srv, ip, accounting)
+		write("user:test", "11-22-33-44-55-66", srv, ip, accounting)
 	}
 }
 
+func writePEM(file, kind string, b []byte) {
+	f, err := os.Create(file)
+	if err != nil {
+		panic("unable to create pem")
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: kind, Bytes: b}); err != nil {
+		panic("unable to write pem")
+	}
+}
+
+func newCert(dir, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("unable to generate key")
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent = tmpl
+		parentKey = key
+	}
+	b, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		panic("unable to create certificate")
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		panic("unable to parse certificate")
+	}
+	writePEM(filepath.Join(dir, name+".pem"), "CERTIFICATE", b)
+	k, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		panic("unable to marshal key")
+	}
+	writePEM(filepath.Join(dir, name+".key"), "EC PRIVATE KEY", k)
+	return cert, key
+}
+
+func generateCerts(dir string) {
+	ca, key := newCert(dir, "ca", 1, nil, nil)
+	newCert(dir, "server", 2, ca, key)
+	newCert(dir, "client", 3, ca, key)
+}
+
+func testRadSec(dir string) {
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		panic("unable to load client certificate")
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		panic("unable to read ca")
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(b)
+	conn, err := tls.Dial("tcp", "localhost:2083", &tls.Config{Certificates: []tls.Certificate{pair}, RootCAs: pool})
+	if err != nil {
+		panic(fmt.Sprintf("unable to dial radsec (%v)", err))
+	}
+	defer conn.Close()
+	count := 0
+	ip := net.IPv4(127, 0, 0, 1)
+	for _, obj := range [][]string{{"user:test", "11-22-33-44-55-66"}, {"user:test@vlan.id", "11-22-33-44-55-67"}, {"user:test", "11-22-33-44-55-66"}} {
+		time.Sleep(1 * time.Second)
+		if _, err := conn.Write(newSecretPacket(obj[0], obj[1], ip, []byte("radsec"))); err != nil {
+			panic("unable to write radsec")
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+			panic("unable to set deadline")
+		}
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			continue
+		}
+		body := make([]byte, int(binary.BigEndian.Uint16(header[2:4]))-len(header))
+		if _, err := io.ReadFull(conn, body); err == nil {
+			count++
+		}
+	}
+	increment("radsec", count)
+}
+
 func main() {
 	endpoint := flag.Bool("endpoint", false, "indicates if running as a fake endpoint")
+	certs := flag.String("certs", "", "generate radsec test certificates into a directory")
+	radsec := flag.String("radsec", "", "run proxy tests over radsec using certificates in a directory")
 	flag.Parse()
+	if *certs != "" {
+		generateCerts(*certs)
+		return
+	}
 	if *endpoint {
 		runEndpoint()
 	} else {
-		test(false)
+		if *radsec != "" {
+			testRadSec(*radsec)
+		} else {
+			test(false)
+		}
 		test(true)
 	}
 }
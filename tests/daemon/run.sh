@@ -16,6 +16,13 @@ done
 
 PATH="${OFFSET}:$PATH"
 CONF="$1"
+TESTARGS=""
+if [[ "$CONF" == "radsec" ]]; then
+    CERTS=${BIN}certs
+    mkdir -p $CERTS
+    go run $HARNESS --certs $CERTS
+    TESTARGS="--radsec $CERTS"
+fi
 
 _discover() {
     local f
@@ -50,11 +57,11 @@ echo "starting harness..."
 go run $HARNESS --endpoint=true &
 sleep 1
 echo "running tests..."
-go run $HARNESS
+go run $HARNESS $TESTARGS
 echo "reloading..."
 _reset
 echo "re-running..."
-go run $HARNESS
+go run $HARNESS $TESTARGS
 sleep 1
 echo "killing..."
 _reset
@@ -79,11 +86,13 @@ for o in accounting; do
     _getaux $o | \
         sed "s/^  //g" | cut -d " " -f 1,3 | \
         sed "s/^Access/ Access/g" | \
+        sed "s/^Accounting/ Accounting/g" | \
         sed "s/^UDPAddr/ UDPAddr/g" | \
         sed "s/^Id/ Id/g" | \
         cut -d " " -f 1,2 | \
         sed "s/ UDPAddr/UDPAddr/g" | \
         sed "s/ Access/Access/g" | \
+        sed "s/ Accounting/Accounting/g" | \
         sort >> bin/$o.log
 done
 
@@ -116,6 +125,22 @@ if [[ "$CONF" == "norjct" ]]; then
     reject=0
 fi
 
+if cat bin/accounting | grep -q "^accounting:12$"; then
+    echo "accounting responses pass"
+else
+    echo "invalid accounting responses"
+    exit 1
+fi
+
+if [[ "$CONF" == "radsec" ]]; then
+    if cat bin/radsec | grep -q "^radsec:6$"; then
+        echo "radsec responses pass"
+    else
+        echo "invalid radsec responses"
+        exit 1
+    fi
+fi
+
 _checks "rejecting client" $reject
 _checks "client failed preauth check" 2
 echo "stdout checks passed"